@@ -0,0 +1,163 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/caching"
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-gateway/auth/password"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	filetypes "github.com/Yulian302/lfusys-services-gateway/files/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFileService struct {
+	files []*filetypes.File
+}
+
+func (f *fakeFileService) GetFiles(ctx context.Context, email string) (*filetypes.FilesResponse, error) {
+	return &filetypes.FilesResponse{Files: f.files}, nil
+}
+
+type accountFixture struct {
+	svc        *services.AccountServiceImpl
+	tokens     *store.RedisTokenStore
+	identities *memoryIdentityStore
+	apiKeys    *memoryAPIKeyStore
+	files      *fakeFileService
+	cache      caching.CachingService
+}
+
+func newAccountFixture(t *testing.T) accountFixture {
+	mockStore.ResetMock()
+
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+	mockStore.On("GetByEmail", mock.Anything, "test@gmail.com").Return(
+		&types.User{ID: "u1", RegisterUser: types.RegisterUser{Email: "test@gmail.com", Name: "Test", Password: hashed}},
+		nil,
+	)
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	f := accountFixture{
+		tokens: store.NewRedisTokenStore(rdb),
+		identities: &memoryIdentityStore{identities: map[string]types.Identity{
+			"github#42": {Provider: "github", ProviderID: "42", UserEmail: "test@gmail.com"},
+		}},
+		apiKeys: &memoryAPIKeyStore{keys: map[string]types.APIKey{
+			"k1": {ID: "k1", UserEmail: "test@gmail.com", KeyHash: "secret-hash"},
+		}},
+		files: &fakeFileService{files: []*filetypes.File{{FileId: "f1", OwnerEmail: "test@gmail.com"}}},
+		cache: caching.NewRedisCachingService(rdb),
+	}
+	authSvc := services.NewAuthServiceImpl(mockStore, nil, f.tokens, nil, "access", "refresh")
+	f.svc = services.NewAccountService(mockStore, f.identities, f.apiKeys, f.files, authSvc, f.cache)
+	return f
+}
+
+func TestAccountExport(t *testing.T) {
+	f := newAccountFixture(t)
+
+	export, err := f.svc.Export(context.Background(), "test@gmail.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, "u1", export.Profile.ID)
+	assert.Len(t, export.Identities, 1)
+	assert.Len(t, export.APIKeys, 1)
+	assert.Len(t, export.Files, 1)
+}
+
+func TestAccountDelete_RequiresReauthentication(t *testing.T) {
+	f := newAccountFixture(t)
+	ctx := context.Background()
+
+	err := f.svc.Delete(ctx, "test@gmail.com", types.DeleteAccountRequest{}, time.Now().Add(-time.Hour).Unix(), types.ClientInfo{IP: "10.0.0.1"})
+	assert.ErrorIs(t, err, services.ErrReauthRequired)
+
+	err = f.svc.Delete(ctx, "test@gmail.com", types.DeleteAccountRequest{Password: "wrong-password"}, time.Now().Unix(), types.ClientInfo{IP: "10.0.0.1"})
+	assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+
+	mockStore.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestAccountDelete_RemovesEverything(t *testing.T) {
+	f := newAccountFixture(t)
+	f.files.files = nil
+	ctx := context.Background()
+	mockStore.On("Delete", mock.Anything, "test@gmail.com").Return(nil)
+
+	err := f.svc.Delete(ctx, "test@gmail.com", types.DeleteAccountRequest{Password: "password123"}, 0, types.ClientInfo{IP: "10.0.0.1"})
+	require.NoError(t, err)
+
+	assert.Empty(t, f.identities.identities)
+	assert.Empty(t, f.apiKeys.keys)
+	mockStore.AssertCalled(t, "Delete", mock.Anything, "test@gmail.com")
+
+	generation, err := f.tokens.Generation(ctx, "test@gmail.com")
+	require.NoError(t, err)
+	assert.Positive(t, generation)
+}
+
+func TestAccountDelete_KeepsAccountWithFiles(t *testing.T) {
+	f := newAccountFixture(t)
+
+	err := f.svc.Delete(context.Background(), "test@gmail.com", types.DeleteAccountRequest{}, time.Now().Unix(), types.ClientInfo{IP: "10.0.0.1"})
+	assert.ErrorIs(t, err, services.ErrAccountHasFiles)
+
+	assert.Len(t, f.identities.identities, 1)
+	mockStore.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestUpdateProfile_Validates(t *testing.T) {
+	f := newAccountFixture(t)
+	ctx := context.Background()
+
+	invalid := []types.UpdateProfileRequest{
+		{DisplayName: ptr("   ")},
+		{AvatarURL: ptr("http://example.com/me.png")},
+		{AvatarURL: ptr("javascript:alert(1)")},
+		{Locale: ptr("not a locale")},
+		{Timezone: ptr("Mars/Olympus_Mons")},
+		{Timezone: ptr("Local")},
+	}
+	for _, req := range invalid {
+		_, err := f.svc.UpdateProfile(ctx, "test@gmail.com", req)
+		assert.ErrorIs(t, err, services.ErrInvalidProfile)
+	}
+	mockStore.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpdateProfile_InvalidatesCache(t *testing.T) {
+	f := newAccountFixture(t)
+	ctx := context.Background()
+
+	require.NoError(t, f.cache.Set(ctx, "user:test@gmail.com", `{"email":"test@gmail.com","name":"Old"}`, time.Hour))
+
+	mockStore.On("Update", mock.Anything, "test@gmail.com", mock.MatchedBy(func(u store.UserUpdate) bool {
+		return *u.Name == "New Name" && *u.Locale == "en-US" && *u.Timezone == "Europe/Kyiv" && *u.AvatarURL == ""
+	})).Return(nil)
+
+	_, err := f.svc.UpdateProfile(ctx, "test@gmail.com", types.UpdateProfileRequest{
+		DisplayName: ptr(" New Name "),
+		AvatarURL:   ptr(""),
+		Locale:      ptr("en-us"),
+		Timezone:    ptr("Europe/Kyiv"),
+	})
+	require.NoError(t, err)
+	mockStore.AssertExpectations(t)
+
+	cached, _ := f.cache.Get(ctx, "user:test@gmail.com")
+	assert.Empty(t, cached)
+}
+
+func ptr(s string) *string {
+	return &s
+}
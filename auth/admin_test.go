@@ -0,0 +1,122 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Yulian302/lfusys-services-commons/test"
+	"github.com/Yulian302/lfusys-services-gateway/auth/password"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func adminLogin(t *testing.T) []string {
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+
+	mockStore.On("GetByEmail", mock.Anything, "admin@gmail.com").Return(
+		&types.User{
+			RegisterUser: types.RegisterUser{Email: "admin@gmail.com", Password: hashed},
+			Roles:        []string{types.RoleUser, types.RoleAdmin},
+		},
+		nil,
+	)
+
+	body, _ := json.Marshal(types.LoginUser{Email: "admin@gmail.com", Password: "password123"})
+	w := test.PerformRequest(r, t, "POST", "/auth/login", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	return []string{"Cookie: jwt=" + responseCookie(w, "jwt")}
+}
+
+func TestAdmin_RequiresAdminRole(t *testing.T) {
+	mockStore.ResetMock()
+
+	w := test.PerformRequest(r, t, "GET", "/admin/users", nil, []string{"Cookie: jwt=" + responseCookie(login(t), "jwt")}, false, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// tokens without roles, e.g. from before roles existed, are no admins either
+	w = test.PerformRequest(r, t, "GET", "/admin/users", nil, nil, true, cfg.JWTConfig.SecretKey, "admin@gmail.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAdmin_ListUsers(t *testing.T) {
+	mockStore.ResetMock()
+	cookies := adminLogin(t)
+
+	mockStore.On("List", mock.Anything, store.UserFilter{Query: "test", Limit: 50}).Return(
+		[]types.User{{RegisterUser: types.RegisterUser{Email: "test@gmail.com", Name: "Test", Password: "hash"}}},
+		"test@gmail.com",
+		nil,
+	)
+
+	w := test.PerformRequest(r, t, "GET", "/admin/users?q=test", nil, cookies, false, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data types.UserList `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Data.Users, 1)
+	assert.Equal(t, "test@gmail.com", resp.Data.Users[0].Email)
+	assert.Equal(t, []string{types.RoleUser}, resp.Data.Users[0].Roles)
+	assert.Equal(t, "test@gmail.com", resp.Data.NextCursor)
+	assert.NotContains(t, w.Body.String(), "hash")
+}
+
+func TestAdmin_DisableEndsSessions(t *testing.T) {
+	mockStore.ResetMock()
+
+	userRefresh := responseCookie(login(t), "refresh_token")
+	cookies := adminLogin(t)
+
+	mockStore.On("Update", mock.Anything, "test@gmail.com", mock.MatchedBy(func(u store.UserUpdate) bool {
+		return u.Disabled != nil && *u.Disabled
+	})).Return(nil)
+
+	w := test.PerformRequest(r, t, "POST", "/admin/users/test@gmail.com/disable", nil, cookies, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = refresh(t, userRefresh)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+
+	// admins cannot lock themselves out
+	w = test.PerformRequest(r, t, "POST", "/admin/users/admin@gmail.com/disable", nil, cookies, false, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = test.PerformRequest(r, t, "POST", "/admin/users/Admin@gmail.com/disable", nil, cookies, false, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// nor take their own admin role away
+	body, _ := json.Marshal(types.SetRolesRequest{Roles: []string{types.RoleUser}})
+	w = test.PerformRequest(r, t, "PUT", "/admin/users/ADMIN@gmail.com/roles", bytes.NewReader(body), append(cookies, "Content-Type: application/json"), false, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockStore.AssertNotCalled(t, "Update", mock.Anything, "ADMIN@gmail.com", mock.Anything)
+}
+
+func TestDisabledUser_Rejected(t *testing.T) {
+	mockStore.ResetMock()
+	userRefresh := responseCookie(login(t), "refresh_token")
+
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+	mockStore.ResetMock()
+	mockStore.On("GetByEmail", mock.Anything, "test@gmail.com").Return(
+		&types.User{
+			RegisterUser: types.RegisterUser{Email: "test@gmail.com", Password: hashed},
+			Disabled:     true,
+		},
+		nil,
+	)
+
+	body, _ := json.Marshal(types.LoginUser{Email: "test@gmail.com", Password: "password123"})
+	w := test.PerformRequest(r, t, "POST", "/auth/login", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = refresh(t, userRefresh)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
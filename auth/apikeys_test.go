@@ -0,0 +1,189 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/test"
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/keys"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryAPIKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]types.APIKey
+}
+
+func (s *memoryAPIKeyStore) Create(ctx context.Context, key types.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *memoryAPIKeyStore) GetByID(ctx context.Context, id string) (*types.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, store.ErrAPIKeyNotFound
+	}
+	return &key, nil
+}
+
+func (s *memoryAPIKeyStore) ListByEmail(ctx context.Context, email string) ([]types.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []types.APIKey
+	for _, k := range s.keys {
+		if k.UserEmail == email {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryAPIKeyStore) Delete(ctx context.Context, email string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.keys[id]; !ok || k.UserEmail != email {
+		return store.ErrAPIKeyNotFound
+	}
+	delete(s.keys, id)
+	return nil
+}
+
+func (s *memoryAPIKeyStore) TouchLastUsed(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.keys[id]
+	k.LastUsedAt = &at
+	s.keys[id] = k
+	return nil
+}
+
+func (s *memoryAPIKeyStore) IsReady(ctx context.Context) error { return nil }
+func (s *memoryAPIKeyStore) Name() string                      { return "memory" }
+
+func TestAPIKey_ScopesAndRevocation(t *testing.T) {
+	keyStore := &memoryAPIKeyStore{keys: map[string]types.APIKey{}}
+	svc := services.NewAPIKeyService(keyStore, mockStore)
+
+	mockStore.ResetMock()
+	mockStore.On("GetByEmail", mock.Anything, "test@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "test@gmail.com"}, Verified: true},
+		nil,
+	)
+
+	engine := gin.New()
+	group := engine.Group("/", auth.JWTMiddleware(keys.HS256(cfg.JWTConfig.SecretKey), nil, svc))
+	group.GET("/files", auth.RequireScope(types.ScopeFilesRead), func(c *gin.Context) { c.String(http.StatusOK, c.GetString("email")) })
+	group.GET("/uploads", auth.RequireScope(types.ScopeUploadsWrite), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	created, err := svc.Create(context.Background(), "test@gmail.com", types.CreateAPIKeyRequest{
+		Name:   "backup",
+		Scopes: []string{types.ScopeFilesRead},
+	})
+	require.NoError(t, err)
+
+	header := []string{"Authorization: Bearer " + created.Key}
+
+	w := test.PerformRequest(engine, t, "GET", "/files", nil, header, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "test@gmail.com", w.Body.String())
+	assert.NotNil(t, keyStore.keys[created.ID].LastUsedAt)
+
+	w = test.PerformRequest(engine, t, "GET", "/uploads", nil, header, false, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = test.PerformRequest(engine, t, "GET", "/files", nil, []string{"Authorization: Bearer " + created.Key + "x"}, false, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	require.NoError(t, svc.Revoke(context.Background(), "test@gmail.com", created.ID))
+
+	w = test.PerformRequest(engine, t, "GET", "/files", nil, header, false, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAPIKey_UsesOwnersVerification(t *testing.T) {
+	keyStore := &memoryAPIKeyStore{keys: map[string]types.APIKey{}}
+	svc := services.NewAPIKeyService(keyStore, mockStore)
+
+	engine := gin.New()
+	group := engine.Group("/", auth.JWTMiddleware(keys.HS256(cfg.JWTConfig.SecretKey), nil, svc), auth.RequireVerified(true))
+	group.GET("/uploads", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	created, err := svc.Create(context.Background(), "unverified@gmail.com", types.CreateAPIKeyRequest{
+		Name:   "ci",
+		Scopes: []string{types.ScopeUploadsWrite},
+	})
+	require.NoError(t, err)
+
+	mockStore.ResetMock()
+	mockStore.On("GetByEmail", mock.Anything, "unverified@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "unverified@gmail.com"}},
+		nil,
+	)
+
+	w := test.PerformRequest(engine, t, "GET", "/uploads", nil, []string{"Authorization: Bearer " + created.Key}, false, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAPIKey_DisabledOwnerRejected(t *testing.T) {
+	keyStore := &memoryAPIKeyStore{keys: map[string]types.APIKey{}}
+	svc := services.NewAPIKeyService(keyStore, mockStore)
+
+	engine := gin.New()
+	group := engine.Group("/", auth.JWTMiddleware(keys.HS256(cfg.JWTConfig.SecretKey), nil, svc))
+	group.GET("/uploads", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	created, err := svc.Create(context.Background(), "disabled@gmail.com", types.CreateAPIKeyRequest{
+		Name:   "ci",
+		Scopes: []string{types.ScopeFilesRead},
+	})
+	require.NoError(t, err)
+
+	mockStore.ResetMock()
+	mockStore.On("GetByEmail", mock.Anything, "disabled@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "disabled@gmail.com"}, Verified: true, Disabled: true},
+		nil,
+	)
+
+	w := test.PerformRequest(engine, t, "GET", "/uploads", nil, []string{"Authorization: Bearer " + created.Key}, false, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAPIKey_CarriesOwnersRoles(t *testing.T) {
+	keyStore := &memoryAPIKeyStore{keys: map[string]types.APIKey{}}
+	svc := services.NewAPIKeyService(keyStore, mockStore)
+
+	engine := gin.New()
+	group := engine.Group("/", auth.JWTMiddleware(keys.HS256(cfg.JWTConfig.SecretKey), nil, svc))
+	group.GET("/uploads", func(c *gin.Context) { c.JSON(http.StatusOK, c.GetStringSlice("roles")) })
+
+	created, err := svc.Create(context.Background(), "pro@gmail.com", types.CreateAPIKeyRequest{
+		Name:   "ci",
+		Scopes: []string{types.ScopeUploadsWrite},
+	})
+	require.NoError(t, err)
+
+	mockStore.ResetMock()
+	mockStore.On("GetByEmail", mock.Anything, "pro@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "pro@gmail.com"}, Verified: true, Roles: []string{"pro", types.RoleUser}},
+		nil,
+	)
+
+	w := test.PerformRequest(engine, t, "GET", "/uploads", nil, []string{"Authorization: Bearer " + created.Key}, false, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `["pro", "user"]`, w.Body.String())
+}
@@ -2,51 +2,135 @@ package auth_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Yulian302/lfusys-services-commons/config"
 	"github.com/Yulian302/lfusys-services-commons/crypt"
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/ratelimit"
 	"github.com/Yulian302/lfusys-services-commons/test"
 	"github.com/Yulian302/lfusys-services-commons/test/mocks"
+	"github.com/Yulian302/lfusys-services-gateway/auth"
 	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/Yulian302/lfusys-services-gateway/auth/keys"
+	"github.com/Yulian302/lfusys-services-gateway/auth/password"
+	"github.com/Yulian302/lfusys-services-gateway/auth/redirect"
+	"github.com/Yulian302/lfusys-services-gateway/auth/totp"
 	"github.com/Yulian302/lfusys-services-gateway/auth/types"
 	authtypes "github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/mail"
 	"github.com/Yulian302/lfusys-services-gateway/routers"
 	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 var (
 	cfg       config.Config
-	mockStore *mocks.MockDynamoDbStore
+	mockStore *userStoreMock
+	mailer    *mail.MemoryMailer
 	r         *gin.Engine
+
+	// what verifying an address takes away from whoever registered it
+	tokenStore         *store.RedisTokenStore
+	takeoverAPIKeys    = &memoryAPIKeyStore{keys: map[string]types.APIKey{}}
+	takeoverIdentities = &memoryIdentityStore{identities: map[string]types.Identity{}}
 )
 
+// userStoreMock adds the gateway specific UserStore methods to the shared mock
+type userStoreMock struct {
+	*mocks.MockDynamoDbStore
+}
+
+func (m *userStoreMock) Update(ctx context.Context, email string, update store.UserUpdate) error {
+	args := m.Called(ctx, email, update)
+	return args.Error(0)
+}
+
+func (m *userStoreMock) ConsumeMFAStep(ctx context.Context, email string, step int64) error {
+	args := m.Called(ctx, email, step)
+	return args.Error(0)
+}
+
+func (m *userStoreMock) ConsumeRecoveryCode(ctx context.Context, email string, codeHash string) error {
+	args := m.Called(ctx, email, codeHash)
+	return args.Error(0)
+}
+
+func (m *userStoreMock) List(ctx context.Context, filter store.UserFilter) ([]types.User, string, error) {
+	args := m.Called(ctx, filter)
+	users, _ := args.Get(0).([]types.User)
+	return users, args.String(1), args.Error(2)
+}
+
+func (m *userStoreMock) Delete(ctx context.Context, email string) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
 func TestMain(m *testing.M) {
 	gin.SetMode(gin.TestMode)
 
 	cfg = config.LoadConfig()
 
-	mockStore = &mocks.MockDynamoDbStore{}
+	mockStore = &userStoreMock{&mocks.MockDynamoDbStore{}}
+
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr: redisServer.Addr(),
+	})
 
 	r = gin.Default()
 
-	authService := services.NewAuthServiceImpl(mockStore, nil, nil, cfg.JWTConfig.SecretKey, cfg.JWTConfig.RefreshSecretKey)
-	authHandler := handlers.NewAuthHandler(authService)
-	routers.RegisterAuthRoutes(authHandler, nil, nil, cfg.JWTConfig.SecretKey, r)
+	tokenStore = store.NewRedisTokenStore(rdb)
+	authService := services.NewAuthServiceImpl(mockStore, nil, tokenStore, nil, cfg.JWTConfig.SecretKey, cfg.JWTConfig.RefreshSecretKey)
+	limiter := ratelimit.NewRedisRateLimiter(rdb)
+	authService.Limiter = limiter
+	mailer = mail.NewMemoryMailer()
+	verificationService := services.NewVerificationService(mockStore, takeoverAPIKeys, takeoverIdentities, authService, mailer, limiter, cfg.JWTConfig.SecretKey, "http://frontend")
+	passwordService := services.NewPasswordService(mockStore, takeoverAPIKeys, takeoverIdentities, store.NewRedisOneTimeTokenStore(rdb), authService, mailer, limiter, "http://frontend")
+
+	returnTo, err := redirect.NewAllowlist("http://frontend", nil)
+	if err != nil {
+		panic(err)
+	}
+
+	authHandler := handlers.NewAuthHandler(authService, verificationService, returnTo)
+	requireAuth := auth.JWTMiddleware(keys.HS256(cfg.JWTConfig.SecretKey), authService, nil)
+	routers.RegisterAuthRoutes(authHandler, nil, requireAuth, r)
+	routers.RegisterVerificationRoutes(handlers.NewVerificationHandler(verificationService), r)
+	routers.RegisterPasswordRoutes(handlers.NewPasswordHandler(passwordService), requireAuth, r)
+	routers.RegisterMFARoutes(handlers.NewMFAHandler(authService, returnTo), requireAuth, r)
+	routers.RegisterAdminRoutes(handlers.NewAdminHandler(services.NewAdminService(mockStore, authService)), requireAuth, r)
+	routers.RegisterSessionRoutes(handlers.NewSessionHandler(authService), requireAuth, r)
+	magicLinkService := services.NewMagicLinkService(mockStore, takeoverAPIKeys, takeoverIdentities, store.NewRedisOneTimeTokenStore(rdb), authService, mailer, limiter, "http://frontend")
+	routers.RegisterMagicLinkRoutes(handlers.NewMagicLinkHandler(magicLinkService, returnTo), r)
 
-	os.Exit(m.Run())
+	code := m.Run()
+	redisServer.Close()
+	os.Exit(code)
 }
 
 func TestLogin_Success(t *testing.T) {
 	mockStore.ResetMock()
 
-	hashed, salt := crypt.HashSHA256WithSalt("password123")
+	hashed, err := password.Hash("password123")
+	assert.NoError(t, err)
 
 	mockStore.On(
 		"GetByEmail",
@@ -54,7 +138,6 @@ func TestLogin_Success(t *testing.T) {
 		"test@gmail.com",
 	).Return(
 		&types.User{
-			Salt: salt,
 			RegisterUser: types.RegisterUser{
 				Email:    "test@gmail.com",
 				Password: hashed,
@@ -87,16 +170,116 @@ func TestLogin_Success(t *testing.T) {
 	mockStore.AssertExpectations(t)
 }
 
+func TestLogin_ReturnTo(t *testing.T) {
+	hashed, err := password.Hash("password123")
+	assert.NoError(t, err)
+
+	cases := map[string]string{
+		"/files/42":                   "http://frontend/files/42",
+		"https://evil.example.com/42": "",
+	}
+	for returnTo, want := range cases {
+		mockStore.ResetMock()
+		mockStore.On("GetByEmail", mock.Anything, "test@gmail.com").Return(
+			&types.User{
+				RegisterUser: types.RegisterUser{
+					Email:    "test@gmail.com",
+					Password: hashed,
+				},
+			},
+			nil,
+		)
+
+		body, _ := json.Marshal(authtypes.LoginUser{
+			Email:    "test@gmail.com",
+			Password: "password123",
+			ReturnTo: returnTo,
+		})
+		w := test.PerformRequest(r, t, "POST", "/auth/login", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp map[string]any
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		if want == "" {
+			assert.NotContains(t, resp, "redirect_to", returnTo)
+		} else {
+			assert.Equal(t, want, resp["redirect_to"], returnTo)
+		}
+	}
+}
+
+func TestLogin_UpgradesLegacyHash(t *testing.T) {
+	mockStore.ResetMock()
+
+	hashed, salt := crypt.HashSHA256WithSalt("password123")
+
+	mockStore.On(
+		"GetByEmail",
+		mock.Anything,
+		"test@gmail.com",
+	).Return(
+		&types.User{
+			Salt: salt,
+			RegisterUser: types.RegisterUser{
+				Email:    "test@gmail.com",
+				Password: hashed,
+			},
+		},
+		nil,
+	)
+	mockStore.On(
+		"Update",
+		mock.Anything,
+		"test@gmail.com",
+		mock.MatchedBy(func(update store.UserUpdate) bool {
+			if update.Password == nil {
+				return false
+			}
+			ok, rehash, err := password.Verify("password123", *update.Password, "")
+			return ok && !rehash && err == nil
+		}),
+	).Return(
+		nil,
+	)
+
+	body, _ := json.Marshal(authtypes.LoginUser{
+		Email:    "test@gmail.com",
+		Password: "password123",
+	})
+
+	w := test.PerformRequest(r, t, "POST", "/auth/login", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockStore.AssertExpectations(t)
+}
+
 func TestRegister_Success(t *testing.T) {
 	mockStore.ResetMock()
 
 	mockStore.On(
 		"Create",
 		mock.Anything,
-		mock.AnythingOfType("types.User"),
+		mock.MatchedBy(func(user types.User) bool {
+			ok, _, _ := password.Verify("password123", user.Password, user.Salt)
+			return ok && user.Salt == ""
+		}),
 	).Return(
 		nil,
 	)
+	mockStore.On(
+		"GetByEmail",
+		mock.Anything,
+		"test@gmail.com",
+	).Return(
+		&types.User{
+			RegisterUser: types.RegisterUser{
+				Name:  "Test",
+				Email: "test@gmail.com",
+			},
+		},
+		nil,
+	)
+	sentBefore := len(mailer.Sent())
 
 	reqBody := types.RegisterUser{
 		Name:     "Test",
@@ -118,5 +301,415 @@ func TestRegister_Success(t *testing.T) {
 	)
 
 	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Len(t, mailer.Sent(), sentBefore+1)
+	mockStore.AssertExpectations(t)
+}
+
+func TestVerifyEmail_Success(t *testing.T) {
+	mockStore.ResetMock()
+
+	mockStore.On(
+		"GetByEmail",
+		mock.Anything,
+		"verify@gmail.com",
+	).Return(
+		&types.User{
+			RegisterUser: types.RegisterUser{
+				Email:    "verify@gmail.com",
+				Password: "registrant-hash",
+			},
+		},
+		nil,
+	)
+	// whoever registered the address loses what they set up
+	mockStore.On(
+		"Update",
+		mock.Anything,
+		"verify@gmail.com",
+		mock.MatchedBy(func(update store.UserUpdate) bool {
+			return update.Verified != nil && *update.Verified && update.Password != nil && *update.Password == ""
+		}),
+	).Return(
+		nil,
+	)
+	takeoverAPIKeys.keys["registrant-key"] = types.APIKey{ID: "registrant-key", UserEmail: "verify@gmail.com"}
+
+	body, _ := json.Marshal(authtypes.ResendVerificationRequest{Email: "verify@gmail.com"})
+	w := test.PerformRequest(r, t, "POST", "/auth/verify/resend", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	sent := mailer.Sent()
+	msg := sent[len(sent)-1]
+	assert.Equal(t, "verify@gmail.com", msg.To)
+
+	_, rest, found := strings.Cut(msg.Body, "token=")
+	assert.True(t, found)
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	assert.NoError(t, err)
+
+	body, _ = json.Marshal(authtypes.VerifyEmailRequest{Token: token})
+	w = test.PerformRequest(r, t, "POST", "/auth/verify", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockStore.AssertCalled(t, "Update", mock.Anything, "verify@gmail.com", mock.Anything)
+	assert.NotContains(t, takeoverAPIKeys.keys, "registrant-key")
+
+	// an access token is no verification token
+	body, _ = json.Marshal(authtypes.VerifyEmailRequest{Token: responseCookie(login(t), "jwt")})
+	w = test.PerformRequest(r, t, "POST", "/auth/verify", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestResendVerification_RateLimited(t *testing.T) {
+	mockStore.ResetMock()
+
+	mockStore.On("GetByEmail", mock.Anything, "limited@gmail.com").Return(nil, errors.ErrUserNotFound)
+
+	body, _ := json.Marshal(authtypes.ResendVerificationRequest{Email: "limited@gmail.com"})
+
+	codes := []int{}
+	for i := 0; i < 4; i++ {
+		w := test.PerformRequest(r, t, "POST", "/auth/verify/resend", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+		codes = append(codes, w.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func login(t *testing.T) *httptest.ResponseRecorder {
+	hashed, err := password.Hash("password123")
+	assert.NoError(t, err)
+
+	mockStore.On(
+		"GetByEmail",
+		mock.Anything,
+		"test@gmail.com",
+	).Return(
+		&types.User{
+			RegisterUser: types.RegisterUser{
+				Email:    "test@gmail.com",
+				Password: hashed,
+			},
+		},
+		nil,
+	)
+
+	body, _ := json.Marshal(authtypes.LoginUser{
+		Email:    "test@gmail.com",
+		Password: "password123",
+	})
+	w := test.PerformRequest(r, t, "POST", "/auth/login", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	return w
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// dataField reads a string field of a JSON response, with or without a data envelope
+func dataField(t *testing.T, w *httptest.ResponseRecorder, key string) string {
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if data, ok := body["data"].(map[string]any); ok {
+		body = data
+	}
+	value, _ := body[key].(string)
+	return value
+}
+
+func refresh(t *testing.T, refreshToken string) *httptest.ResponseRecorder {
+	return test.PerformRequest(
+		r,
+		t,
+		"POST",
+		"/auth/refresh",
+		nil,
+		[]string{"Cookie: refresh_token=" + refreshToken},
+		false,
+		"",
+		"",
+	)
+}
+
+func TestRefresh_RotatesToken(t *testing.T) {
+	mockStore.ResetMock()
+
+	oldRefresh := responseCookie(login(t), "refresh_token")
+
+	w := refresh(t, oldRefresh)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	newRefresh := responseCookie(w, "refresh_token")
+	assert.NotEmpty(t, newRefresh)
+	assert.NotEqual(t, oldRefresh, newRefresh)
+
+	w = refresh(t, newRefresh)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRefresh_ReuseRevokesFamily(t *testing.T) {
+	mockStore.ResetMock()
+
+	oldRefresh := responseCookie(login(t), "refresh_token")
+
+	w := refresh(t, oldRefresh)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	newRefresh := responseCookie(w, "refresh_token")
+
+	// replaying the rotated token revokes the whole family
+	w = refresh(t, oldRefresh)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = refresh(t, newRefresh)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestLogout_RevokesAccessToken(t *testing.T) {
+	mockStore.ResetMock()
+
+	w := login(t)
+	accessToken := responseCookie(w, "jwt")
+	refreshToken := responseCookie(w, "refresh_token")
+	cookies := []string{"Cookie: jwt=" + accessToken + "; refresh_token=" + refreshToken}
+
+	w = test.PerformRequest(r, t, "POST", "/auth/logout", nil, cookies, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = test.PerformRequest(r, t, "POST", "/auth/logout/all", nil, cookies, false, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "token_revoked")
+
+	w = refresh(t, refreshToken)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestLogoutAll_RevokesEverySession(t *testing.T) {
+	mockStore.ResetMock()
+
+	first := login(t)
+	second := login(t)
+
+	w := test.PerformRequest(r, t, "POST", "/auth/logout/all", nil, []string{"Cookie: jwt=" + responseCookie(first, "jwt")}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = refresh(t, responseCookie(second, "refresh_token"))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+
+	// sessions started afterwards are not affected
+	w = refresh(t, responseCookie(login(t), "refresh_token"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestBearerToken_Accepted(t *testing.T) {
+	mockStore.ResetMock()
+
+	accessToken := responseCookie(login(t), "jwt")
+
+	w := test.PerformRequest(r, t, "POST", "/auth/logout/all", nil, []string{"Authorization: Bearer " + accessToken}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestBearerToken_WinsOverCookie(t *testing.T) {
+	mockStore.ResetMock()
+
+	accessToken := responseCookie(login(t), "jwt")
+
+	w := test.PerformRequest(
+		r,
+		t,
+		"POST",
+		"/auth/logout/all",
+		nil,
+		[]string{"Authorization: Bearer not-a-token", "Cookie: jwt=" + accessToken},
+		false,
+		"",
+		"",
+	)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRefresh_TokensInBody(t *testing.T) {
+	mockStore.ResetMock()
+
+	refreshToken := responseCookie(login(t), "refresh_token")
+	body, _ := json.Marshal(authtypes.RefreshRequest{RefreshToken: refreshToken})
+
+	w := test.PerformRequest(r, t, "POST", "/auth/refresh", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "access_token")
+	assert.Empty(t, w.Result().Cookies())
+}
+
+func TestRefresh_EmptyJSONBodyUsesCookie(t *testing.T) {
+	mockStore.ResetMock()
+
+	refreshToken := responseCookie(login(t), "refresh_token")
+
+	w := test.PerformRequest(r, t, "POST", "/auth/refresh", nil, []string{"Content-Type: application/json", "Cookie: refresh_token=" + refreshToken}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, responseCookie(w, "refresh_token"))
+}
+
+func TestPasswordReset_RevokesSessions(t *testing.T) {
+	mockStore.ResetMock()
+
+	session := login(t)
+	mockStore.On(
+		"Update",
+		mock.Anything,
+		"test@gmail.com",
+		mock.MatchedBy(func(update store.UserUpdate) bool {
+			if update.Password == nil {
+				return false
+			}
+			ok, _, err := password.Verify("newpassword", *update.Password, "")
+			return ok && err == nil
+		}),
+	).Return(
+		nil,
+	)
+
+	body, _ := json.Marshal(authtypes.ForgotPasswordRequest{Email: "test@gmail.com"})
+	w := test.PerformRequest(r, t, "POST", "/auth/password/forgot", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	sent := mailer.Sent()
+	msg := sent[len(sent)-1]
+	assert.Equal(t, "test@gmail.com", msg.To)
+
+	_, rest, found := strings.Cut(msg.Body, "token=")
+	assert.True(t, found)
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	assert.NoError(t, err)
+
+	body, _ = json.Marshal(authtypes.ResetPasswordRequest{Token: token, Password: "newpassword"})
+	w = test.PerformRequest(r, t, "POST", "/auth/password/reset", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// reset links are single-use
+	w = test.PerformRequest(r, t, "POST", "/auth/password/reset", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = refresh(t, responseCookie(session, "refresh_token"))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestPasswordReset_TakesOverUnverifiedAccount(t *testing.T) {
+	mockStore.ResetMock()
+
+	// registered by someone who never proved the address
+	mockStore.On("GetByEmail", mock.Anything, "victim@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "victim@gmail.com", Password: "attacker-hash"}, MFAEnabled: true, MFASecret: "attacker-secret"},
+		nil,
+	)
+	mockStore.On("Update", mock.Anything, "victim@gmail.com", mock.MatchedBy(func(u store.UserUpdate) bool {
+		ok, _, err := password.Verify("newpassword", *u.Password, "")
+		return ok && err == nil && *u.Verified && !*u.MFAEnabled && *u.MFASecret == "" && len(*u.MFARecoveryCodes) == 0
+	})).Return(nil).Once()
+	takeoverAPIKeys.keys["attacker-key"] = types.APIKey{ID: "attacker-key", UserEmail: "victim@gmail.com"}
+	takeoverIdentities.identities["github#666"] = types.Identity{Provider: "github", ProviderID: "666", UserEmail: "victim@gmail.com"}
+
+	body, _ := json.Marshal(authtypes.ForgotPasswordRequest{Email: "victim@gmail.com"})
+	w := test.PerformRequest(r, t, "POST", "/auth/password/forgot", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	sent := mailer.Sent()
+	_, rest, found := strings.Cut(sent[len(sent)-1].Body, "token=")
+	assert.True(t, found)
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	assert.NoError(t, err)
+
+	body, _ = json.Marshal(authtypes.ResetPasswordRequest{Token: token, Password: "newpassword"})
+	w = test.PerformRequest(r, t, "POST", "/auth/password/reset", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockStore.AssertExpectations(t)
+	assert.NotContains(t, takeoverAPIKeys.keys, "attacker-key")
+	assert.NotContains(t, takeoverIdentities.identities, "github#666")
+}
+
+func TestPasswordChange_RequiresCurrentPassword(t *testing.T) {
+	mockStore.ResetMock()
+
+	cookies := []string{"Content-Type: application/json", "Cookie: jwt=" + responseCookie(login(t), "jwt")}
+
+	body, _ := json.Marshal(authtypes.ChangePasswordRequest{CurrentPassword: "wrongpassword", NewPassword: "newpassword"})
+	w := test.PerformRequest(r, t, "POST", "/auth/password/change", bytes.NewReader(body), cookies, false, "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockStore.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything)
+
+	mockStore.On("Update", mock.Anything, "test@gmail.com", mock.Anything).Return(nil)
+
+	body, _ = json.Marshal(authtypes.ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "newpassword"})
+	w = test.PerformRequest(r, t, "POST", "/auth/password/change", bytes.NewReader(body), cookies, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestLogin_MFARequired(t *testing.T) {
+	mockStore.ResetMock()
+
+	hashed, err := password.Hash("password123")
+	assert.NoError(t, err)
+	secret, err := totp.GenerateSecret()
+	assert.NoError(t, err)
+
+	mockStore.On(
+		"GetByEmail",
+		mock.Anything,
+		"mfa@gmail.com",
+	).Return(
+		&types.User{
+			RegisterUser: types.RegisterUser{
+				Email:    "mfa@gmail.com",
+				Password: hashed,
+			},
+			MFAEnabled: true,
+			MFASecret:  secret,
+		},
+		nil,
+	)
+	mockStore.On("ConsumeMFAStep", mock.Anything, "mfa@gmail.com", mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(authtypes.LoginUser{
+		Email:    "mfa@gmail.com",
+		Password: "password123",
+	})
+	w := test.PerformRequest(r, t, "POST", "/auth/login", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+
+	mfaToken := dataField(t, w, "mfa_token")
+	assert.NotEmpty(t, mfaToken)
+
+	stale, err := totp.Code(secret, totp.Step(time.Now())-10)
+	assert.NoError(t, err)
+	body, _ = json.Marshal(authtypes.MFAVerifyRequest{MFAToken: mfaToken, Code: stale})
+	w = test.PerformRequest(r, t, "POST", "/auth/mfa/verify", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	body, _ = json.Marshal(authtypes.MFAVerifyRequest{MFAToken: mfaToken, Code: code})
+	w = test.PerformRequest(r, t, "POST", "/auth/mfa/verify", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, responseCookie(w, "jwt"))
+
+	// the pending token only completes one login
+	w = test.PerformRequest(r, t, "POST", "/auth/mfa/verify", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
 	mockStore.AssertExpectations(t)
 }
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	cerror "errors"
+	"log"
+	"net/http"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/gin-gonic/gin"
+)
+
+type AccountHandler struct {
+	accountService services.AccountService
+}
+
+func NewAccountHandler(accountService services.AccountService) *AccountHandler {
+	return &AccountHandler{
+		accountService: accountService,
+	}
+}
+
+func (h *AccountHandler) UpdateProfile(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	var req types.UpdateProfileRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	user, err := h.accountService.UpdateProfile(ctx, email, req)
+	if err != nil {
+		if cerror.Is(err, services.ErrInvalidProfile) {
+			errors.BadRequestResponse(ctx, err.Error())
+		} else if cerror.Is(err, errors.ErrUserNotFound) {
+			errors.UnauthorizedResponse(ctx, "user not found")
+		} else {
+			log.Printf("could not update profile of %s: %v", email, err)
+			errors.InternalServerErrorResponse(ctx, "could not update profile")
+		}
+		return
+	}
+
+	responses.JSONData(ctx, http.StatusOK, types.NewMeResponse(user))
+}
+
+func (h *AccountHandler) Export(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	export, err := h.accountService.Export(ctx, email)
+	if err != nil {
+		log.Printf("could not export account of %s: %v", email, err)
+		errors.InternalServerErrorResponse(ctx, "could not export account")
+		return
+	}
+
+	ctx.Header("Content-Disposition", `attachment; filename="account-export.json"`)
+	responses.JSONData(ctx, http.StatusOK, export)
+}
+
+func (h *AccountHandler) Delete(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	var req types.DeleteAccountRequest
+	if ctx.ContentType() == "application/json" {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			errors.BadRequestResponse(ctx, err.Error())
+			return
+		}
+	}
+
+	if err := h.accountService.Delete(ctx, email, req, ctx.GetInt64("auth_time"), clientInfo(ctx)); err != nil {
+		if cerror.Is(err, errors.ErrInvalidCredentials) || cerror.Is(err, services.ErrInvalidMFACode) || cerror.Is(err, services.ErrReauthRequired) {
+			errors.UnauthorizedResponse(ctx, err.Error())
+		} else if cerror.Is(err, services.ErrLoginLocked) || cerror.Is(err, services.ErrTooManyMFAAttempts) {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+		} else if cerror.Is(err, services.ErrAccountHasFiles) {
+			errors.ConflictResponse(ctx, err.Error())
+		} else {
+			log.Printf("could not delete account of %s: %v", email, err)
+			errors.InternalServerErrorResponse(ctx, "could not delete account")
+		}
+		return
+	}
+
+	ctx.SetCookie("jwt", "", -1, jwttypes.CookiePath, "", false, true)
+	ctx.SetCookie("refresh_token", "", -1, jwttypes.CookiePath, "", false, true)
+	responses.JSONSuccess(ctx, "account deleted")
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	cerror "errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	adminService services.AdminService
+}
+
+func NewAdminHandler(adminService services.AdminService) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+	}
+}
+
+// ListUsers returns a page of users, optionally only those whose email or name contains q
+func (h *AdminHandler) ListUsers(ctx *gin.Context) {
+	filter := store.UserFilter{
+		Query:  ctx.Query("q"),
+		Cursor: ctx.Query("cursor"),
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil || n <= 0 {
+			errors.BadRequestResponse(ctx, "limit must be a positive number")
+			return
+		}
+		filter.Limit = int32(n)
+	}
+
+	list, err := h.adminService.ListUsers(ctx, filter)
+	if err != nil {
+		errors.InternalServerErrorResponse(ctx, "could not list users")
+		return
+	}
+
+	responses.JSONData(ctx, http.StatusOK, list)
+}
+
+func (h *AdminHandler) Disable(ctx *gin.Context) {
+	h.setDisabled(ctx, true)
+}
+
+func (h *AdminHandler) Enable(ctx *gin.Context) {
+	h.setDisabled(ctx, false)
+}
+
+func (h *AdminHandler) setDisabled(ctx *gin.Context, disabled bool) {
+	if err := h.adminService.SetDisabled(ctx, ctx.GetString("email"), ctx.Param("email"), disabled); err != nil {
+		adminError(ctx, err, "could not update user")
+		return
+	}
+
+	if disabled {
+		responses.JSONSuccess(ctx, "user disabled")
+	} else {
+		responses.JSONSuccess(ctx, "user enabled")
+	}
+}
+
+func (h *AdminHandler) SetRoles(ctx *gin.Context) {
+	var req types.SetRolesRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.adminService.SetRoles(ctx, ctx.GetString("email"), ctx.Param("email"), req.Roles); err != nil {
+		adminError(ctx, err, "could not update roles")
+		return
+	}
+
+	responses.JSONSuccess(ctx, "roles updated")
+}
+
+func (h *AdminHandler) Logout(ctx *gin.Context) {
+	if err := h.adminService.ForceLogout(ctx, ctx.GetString("email"), ctx.Param("email")); err != nil {
+		adminError(ctx, err, "could not log out user")
+		return
+	}
+
+	responses.JSONSuccess(ctx, "user logged out everywhere")
+}
+
+func adminError(ctx *gin.Context, err error, message string) {
+	if cerror.Is(err, errors.ErrUserNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	} else if cerror.Is(err, services.ErrUnknownRole) {
+		errors.BadRequestResponse(ctx, err.Error())
+	} else if cerror.Is(err, services.ErrOwnAccount) {
+		errors.ForbiddenResponse(ctx, err.Error())
+	} else {
+		errors.InternalServerErrorResponse(ctx, message)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	error "errors"
+	"net/http"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyHandler struct {
+	apiKeyService services.APIKeyService
+}
+
+func NewAPIKeyHandler(apiKeyService services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+	}
+}
+
+func (h *APIKeyHandler) Create(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	var req types.CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	created, err := h.apiKeyService.Create(ctx, email, req)
+	if err != nil {
+		if error.Is(err, services.ErrInvalidScope) {
+			errors.BadRequestResponse(ctx, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not create api key")
+		}
+		return
+	}
+
+	responses.JSONData(ctx, http.StatusCreated, created)
+}
+
+func (h *APIKeyHandler) List(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	keys, err := h.apiKeyService.List(ctx, email)
+	if err != nil {
+		errors.InternalServerErrorResponse(ctx, "could not list api keys")
+		return
+	}
+
+	responses.JSONData(ctx, http.StatusOK, keys)
+}
+
+func (h *APIKeyHandler) Revoke(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	id := ctx.Param("id")
+	if id == "" {
+		errors.BadRequestResponse(ctx, "api key id is required")
+		return
+	}
+
+	if err := h.apiKeyService.Revoke(ctx, email, id); err != nil {
+		if error.Is(err, store.ErrAPIKeyNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not revoke api key")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "api key revoked")
+}
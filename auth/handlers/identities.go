@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	error "errors"
+	"net/http"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/gin-gonic/gin"
+)
+
+type IdentityHandler struct {
+	identityService services.IdentityService
+}
+
+func NewIdentityHandler(identityService services.IdentityService) *IdentityHandler {
+	return &IdentityHandler{
+		identityService: identityService,
+	}
+}
+
+func (h *IdentityHandler) List(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	identities, err := h.identityService.List(ctx, email)
+	if err != nil {
+		errors.InternalServerErrorResponse(ctx, "could not list identities")
+		return
+	}
+
+	responses.JSONData(ctx, http.StatusOK, identities)
+}
+
+func (h *IdentityHandler) ConfirmLink(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	var req types.ConfirmLinkRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.identityService.ConfirmLink(ctx, email, req.LinkToken); err != nil {
+		if error.Is(err, errors.ErrInvalidToken) || error.Is(err, errors.ErrInvalidTokenType) {
+			errors.BadRequestResponse(ctx, "invalid or expired link token")
+		} else if error.Is(err, services.ErrIdentityLinkedElsewhere) {
+			errors.ConflictResponse(ctx, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not link identity")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "identity linked")
+}
+
+func (h *IdentityHandler) Unlink(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	if err := h.identityService.Unlink(ctx, email, ctx.Param("provider"), ctx.Param("provider_id")); err != nil {
+		if error.Is(err, store.ErrIdentityNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "identity not found"})
+		} else if error.Is(err, services.ErrLastLoginMethod) {
+			errors.ConflictResponse(ctx, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not unlink identity")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "identity unlinked")
+}
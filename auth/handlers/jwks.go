@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-gateway/auth/keys"
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSHandler struct {
+	keys *keys.KeySet
+}
+
+func NewJWKSHandler(keys *keys.KeySet) *JWKSHandler {
+	return &JWKSHandler{
+		keys: keys,
+	}
+}
+
+// JWKS publishes the public keys that verify access tokens. Without
+// asymmetric keys the set is empty.
+func (h *JWKSHandler) JWKS(ctx *gin.Context) {
+	// verifiers may cache the set, new keys are published before they sign
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, h.keys.JWKS(time.Now()))
+}
@@ -2,30 +2,37 @@ package handlers
 
 import (
 	error "errors"
+	"io"
+	"log"
 	"net/http"
 
-	"github.com/Yulian302/lfusys-services-commons/crypt"
 	"github.com/Yulian302/lfusys-services-commons/errors"
 	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
 	"github.com/Yulian302/lfusys-services-commons/responses"
-	"github.com/Yulian302/lfusys-services-gateway/auth/oauth"
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/redirect"
 	"github.com/Yulian302/lfusys-services-gateway/auth/types"
 	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	authService services.AuthService
+	authService         services.AuthService
+	verificationService services.VerificationService
+	returnTo            *redirect.Allowlist
 }
 
-func NewAuthHandler(authService services.AuthService) *AuthHandler {
+func NewAuthHandler(authService services.AuthService, verificationService services.VerificationService, returnTo *redirect.Allowlist) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
+		authService:         authService,
+		verificationService: verificationService,
+		returnTo:            returnTo,
 	}
 }
 
 func (h *AuthHandler) Me(ctx *gin.Context) {
-	token, err := ctx.Cookie("jwt")
+	token, _, err := auth.AccessToken(ctx)
 	if err != nil || token == "" {
 		errors.UnauthorizedResponse(ctx, "unauthorized")
 		return
@@ -41,11 +48,7 @@ func (h *AuthHandler) Me(ctx *gin.Context) {
 		return
 	}
 
-	responses.JSONData(ctx, http.StatusOK, types.MeResponse{
-		Email:         user.Email,
-		Name:          user.Name,
-		Authenticated: true,
-	})
+	responses.JSONData(ctx, http.StatusOK, types.NewMeResponse(user))
 
 }
 
@@ -65,6 +68,12 @@ func (h *AuthHandler) Register(ctx *gin.Context) {
 		return
 	}
 
+	if h.verificationService != nil {
+		if err := h.verificationService.SendVerification(ctx, req.Email); err != nil {
+			log.Printf("could not send verification email to %s: %v", req.Email, err)
+		}
+	}
+
 	responses.JSONCreated(ctx, "created")
 }
 
@@ -76,16 +85,42 @@ func (h *AuthHandler) Login(ctx *gin.Context) {
 		return
 	}
 
-	loginResp, err := h.authService.Login(ctx, loginUser.Email, loginUser.Password)
+	loginResp, err := h.authService.Login(ctx, loginUser.Email, loginUser.Password, clientInfo(ctx))
 	if err != nil {
 		if error.Is(err, errors.ErrInvalidCredentials) {
 			errors.UnauthorizedResponse(ctx, err.Error())
+		} else if error.Is(err, services.ErrLoginLocked) {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+		} else if error.Is(err, services.ErrEmailNotVerified) {
+			errors.ForbiddenResponse(ctx, "email not verified")
+		} else if error.Is(err, services.ErrAccountDisabled) {
+			errors.ForbiddenResponse(ctx, err.Error())
 		} else {
 			errors.InternalServerErrorResponse(ctx, err.Error())
 		}
 		return
 	}
 
+	if loginResp.MFAToken != "" {
+		responses.JSONData(ctx, http.StatusOK, types.MFAChallenge{
+			MFARequired: true,
+			MFAToken:    loginResp.MFAToken,
+		})
+		return
+	}
+
+	redirectTo, _ := h.returnTo.Resolve(loginUser.ReturnTo)
+	sessionResponse(ctx, loginResp, loginUser.ReturnTokens, "login successful", redirectTo)
+}
+
+// sessionResponse hands out a new session, as cookies for browsers or in the body if asked for.
+// Browsers are told where to continue when redirectTo is set.
+func sessionResponse(ctx *gin.Context, loginResp *services.LoginResponse, returnTokens bool, message string, redirectTo string) {
+	if returnTokens {
+		responses.JSONData(ctx, http.StatusOK, tokenResponse(loginResp.AccessToken, loginResp.RefreshToken))
+		return
+	}
+
 	// set refresh token (30 days)
 	ctx.SetCookie(
 		"refresh_token",
@@ -108,19 +143,46 @@ func (h *AuthHandler) Login(ctx *gin.Context) {
 		true,
 	)
 
-	responses.JSONSuccess(ctx, "login successful")
+	if redirectTo != "" {
+		ctx.JSON(http.StatusOK, gin.H{"message": message, "redirect_to": redirectTo})
+		return
+	}
+	responses.JSONSuccess(ctx, message)
 }
 
 func (h *AuthHandler) Refresh(ctx *gin.Context) {
-	oldRefreshToken, err := ctx.Cookie("refresh_token")
-	if err != nil || oldRefreshToken == "" {
+	// non-browser clients send the refresh token in the body and get the new pair back the same way
+	var req types.RefreshRequest
+	// an empty body falls back to the cookie
+	if ctx.ContentType() == "application/json" && ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil && !error.Is(err, io.EOF) {
+			errors.BadRequestResponse(ctx, err.Error())
+			return
+		}
+	}
+	inBody := req.RefreshToken != ""
+
+	oldRefreshToken := req.RefreshToken
+	if !inBody {
+		oldRefreshToken, _ = ctx.Cookie("refresh_token")
+	}
+	if oldRefreshToken == "" {
 		errors.UnauthorizedResponse(ctx, "missing refresh token")
 		return
 	}
 
-	tokenPair, err := h.authService.RefreshToken(ctx, oldRefreshToken)
+	tokenPair, err := h.authService.RefreshToken(ctx, oldRefreshToken, clientInfo(ctx))
 	if err != nil {
-		if error.Is(err, errors.ErrUserNotFound) || error.Is(err, errors.ErrInvalidToken) || error.Is(err, errors.ErrInvalidTokenType) {
+		if error.Is(err, store.ErrTokenReused) {
+			// the whole token family is revoked, so the cookies are useless now
+			ctx.SetCookie("jwt", "", -1, jwttypes.CookiePath, "", false, true)
+			ctx.SetCookie("refresh_token", "", -1, jwttypes.CookiePath, "", false, true)
+			errors.UnauthorizedResponse(ctx, "refresh token reuse detected")
+		} else if error.Is(err, services.ErrAccountDisabled) {
+			ctx.SetCookie("jwt", "", -1, jwttypes.CookiePath, "", false, true)
+			ctx.SetCookie("refresh_token", "", -1, jwttypes.CookiePath, "", false, true)
+			errors.ForbiddenResponse(ctx, err.Error())
+		} else if error.Is(err, errors.ErrUserNotFound) || error.Is(err, errors.ErrInvalidToken) || error.Is(err, errors.ErrInvalidTokenType) {
 			errors.ConflictResponse(ctx, err.Error())
 		} else {
 			errors.InternalServerErrorResponse(ctx, err.Error())
@@ -128,31 +190,68 @@ func (h *AuthHandler) Refresh(ctx *gin.Context) {
 		return
 	}
 
+	if inBody {
+		responses.JSONData(ctx, http.StatusOK, tokenResponse(tokenPair.AccessToken, tokenPair.RefreshToken))
+		return
+	}
+
 	ctx.SetCookie("jwt", tokenPair.AccessToken, int(jwttypes.AccessTokenDuration), jwttypes.CookiePath, "", false, true)
 	ctx.SetCookie("refresh_token", tokenPair.RefreshToken, int(jwttypes.RefreshTokenDuration), jwttypes.CookiePath, "", false, true)
 	responses.JSONSuccess(ctx, "token refreshed")
 }
 
 func (h *AuthHandler) Logout(ctx *gin.Context) {
+	accessToken, _, _ := auth.AccessToken(ctx)
+
+	var req types.LogoutRequest
+	if ctx.ContentType() == "application/json" {
+		_ = ctx.ShouldBindJSON(&req)
+	}
+	refreshToken := req.RefreshToken
+	if refreshToken == "" {
+		refreshToken, _ = ctx.Cookie("refresh_token")
+	}
+
 	ctx.SetCookie("jwt", "", -1, jwttypes.CookiePath, "", false, true)
 	ctx.SetCookie("refresh_token", "", -1, jwttypes.CookiePath, "", false, true)
+
+	if err := h.authService.Logout(ctx, accessToken, refreshToken); err != nil {
+		errors.InternalServerErrorResponse(ctx, "could not revoke session")
+		return
+	}
+
 	responses.JSONSuccess(ctx, "logged out")
 }
 
-func (h *AuthHandler) NewState(c *gin.Context) {
-	state, err := crypt.GenerateState(16)
-	if err != nil {
-		errors.InternalServerErrorResponse(c, "failed to generate state")
+func (h *AuthHandler) LogoutAll(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
 		return
 	}
 
-	err = h.authService.SaveState(c, oauth.OAuthPrefix+state)
-	if err != nil {
-		errors.InternalServerErrorResponse(c, "failed to store state")
+	if err := h.authService.LogoutAll(ctx, email); err != nil {
+		errors.InternalServerErrorResponse(ctx, "could not revoke sessions")
 		return
 	}
 
-	responses.JSONData(c, http.StatusOK, gin.H{
-		"state": state,
-	})
+	ctx.SetCookie("jwt", "", -1, jwttypes.CookiePath, "", false, true)
+	ctx.SetCookie("refresh_token", "", -1, jwttypes.CookiePath, "", false, true)
+	responses.JSONSuccess(ctx, "logged out everywhere")
+}
+
+func tokenResponse(accessToken, refreshToken string) types.TokenResponse {
+	return types.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(jwttypes.AccessTokenDuration.Seconds()),
+	}
+}
+
+func clientInfo(ctx *gin.Context) types.ClientInfo {
+	return types.ClientInfo{
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
 }
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	error "errors"
+	"net/http"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/redirect"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/gin-gonic/gin"
+)
+
+type MagicLinkHandler struct {
+	magicLinkService services.MagicLinkService
+	returnTo         *redirect.Allowlist
+}
+
+func NewMagicLinkHandler(magicLinkService services.MagicLinkService, returnTo *redirect.Allowlist) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		magicLinkService: magicLinkService,
+		returnTo:         returnTo,
+	}
+}
+
+func (h *MagicLinkHandler) Send(ctx *gin.Context) {
+	var req types.MagicLinkRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.magicLinkService.Send(ctx, req.Email); err != nil {
+		if error.Is(err, services.ErrTooManyRequests) {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "too many sign in emails, try again later"})
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not send sign in email")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "a sign in link was sent")
+}
+
+func (h *MagicLinkHandler) Verify(ctx *gin.Context) {
+	var req types.VerifyMagicLinkRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	loginResp, err := h.magicLinkService.Verify(ctx, req.Token, clientInfo(ctx))
+	if err != nil {
+		if error.Is(err, errors.ErrInvalidToken) {
+			errors.UnauthorizedResponse(ctx, "invalid or expired sign in link")
+		} else if error.Is(err, services.ErrAccountDisabled) {
+			errors.ForbiddenResponse(ctx, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not sign in")
+		}
+		return
+	}
+
+	if loginResp.MFAToken != "" {
+		responses.JSONData(ctx, http.StatusOK, types.MFAChallenge{
+			MFARequired: true,
+			MFAToken:    loginResp.MFAToken,
+		})
+		return
+	}
+
+	redirectTo, _ := h.returnTo.Resolve(req.ReturnTo)
+	sessionResponse(ctx, loginResp, req.ReturnTokens, "login successful", redirectTo)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	error "errors"
+	"net/http"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/redirect"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	authService services.AuthService
+	returnTo    *redirect.Allowlist
+}
+
+func NewMFAHandler(authService services.AuthService, returnTo *redirect.Allowlist) *MFAHandler {
+	return &MFAHandler{
+		authService: authService,
+		returnTo:    returnTo,
+	}
+}
+
+func (h *MFAHandler) Setup(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "unauthorized")
+		return
+	}
+
+	setup, err := h.authService.SetupMFA(ctx, email)
+	if err != nil {
+		if error.Is(err, services.ErrMFAAlreadyEnabled) {
+			errors.ConflictResponse(ctx, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not set up mfa")
+		}
+		return
+	}
+
+	responses.JSONData(ctx, http.StatusOK, setup)
+}
+
+func (h *MFAHandler) Confirm(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "unauthorized")
+		return
+	}
+
+	var req types.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	codes, err := h.authService.ConfirmMFA(ctx, email, req.Code)
+	if err != nil {
+		if error.Is(err, services.ErrMFAAlreadyEnabled) {
+			errors.ConflictResponse(ctx, err.Error())
+		} else if error.Is(err, services.ErrMFANotInitiated) || error.Is(err, services.ErrInvalidMFACode) {
+			errors.BadRequestResponse(ctx, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not enable mfa")
+		}
+		return
+	}
+
+	responses.JSONData(ctx, http.StatusOK, types.MFARecoveryCodesResponse{
+		RecoveryCodes: codes,
+	})
+}
+
+func (h *MFAHandler) Disable(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "unauthorized")
+		return
+	}
+
+	var req types.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.authService.DisableMFA(ctx, email, req.Code, clientInfo(ctx)); err != nil {
+		if error.Is(err, services.ErrMFANotEnabled) || error.Is(err, services.ErrInvalidMFACode) {
+			errors.BadRequestResponse(ctx, err.Error())
+		} else if error.Is(err, services.ErrTooManyMFAAttempts) || error.Is(err, services.ErrLoginLocked) {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, try again later"})
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not disable mfa")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "mfa disabled")
+}
+
+func (h *MFAHandler) Verify(ctx *gin.Context) {
+	var req types.MFAVerifyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	loginResp, err := h.authService.VerifyMFA(ctx, req.MFAToken, req.Code, clientInfo(ctx))
+	if err != nil {
+		if error.Is(err, services.ErrInvalidMFACode) || error.Is(err, errors.ErrInvalidToken) || error.Is(err, errors.ErrInvalidTokenType) {
+			errors.UnauthorizedResponse(ctx, err.Error())
+		} else if error.Is(err, services.ErrTooManyMFAAttempts) || error.Is(err, services.ErrLoginLocked) {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, try again later"})
+		} else if error.Is(err, services.ErrAccountDisabled) {
+			errors.ForbiddenResponse(ctx, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not verify mfa code")
+		}
+		return
+	}
+
+	redirectTo, _ := h.returnTo.Resolve(req.ReturnTo)
+	sessionResponse(ctx, loginResp, req.ReturnTokens, "login successful", redirectTo)
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	cerror "errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/oauth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/redirect"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oauthStateCookie     = "oauth_state"
+	oauthStateCookiePath = "/auth"
+)
+
+// OAuthHandler serves the login flow of every configured provider
+type OAuthHandler struct {
+	frontendURL string
+	returnTo    *redirect.Allowlist
+	authSvc     services.AuthService
+	identitySvc services.IdentityService
+	providers   oauth.Registry
+}
+
+func NewOAuthHandler(frontendURL string, returnTo *redirect.Allowlist, authSvc services.AuthService, identitySvc services.IdentityService, providers oauth.Registry) *OAuthHandler {
+	return &OAuthHandler{
+		frontendURL: frontendURL,
+		returnTo:    returnTo,
+		authSvc:     authSvc,
+		identitySvc: identitySvc,
+		providers:   providers,
+	}
+}
+
+// Login redirects the browser to the provider's authorization page. An
+// allowed ?return_to= is where the callback sends the user afterwards.
+func (h *OAuthHandler) Login(c *gin.Context) {
+	authURL, _, ok := h.begin(c, c.Param("provider"), "")
+	if !ok {
+		return
+	}
+
+	responses.Redirect(c, authURL)
+}
+
+// NewState starts a login with ?provider=<name> for frontends that redirect
+// the user themselves
+func (h *OAuthHandler) NewState(c *gin.Context) {
+	name := c.Query("provider")
+	if name == "" {
+		errors.BadRequestResponse(c, "provider is required")
+		return
+	}
+
+	authURL, state, ok := h.begin(c, name, "")
+	if !ok {
+		return
+	}
+
+	responses.JSONData(c, http.StatusOK, gin.H{
+		"state":             state,
+		"authorization_url": authURL,
+	})
+}
+
+// StartLink starts a login with ?provider=<name> whose identity is linked
+// to the current user
+func (h *OAuthHandler) StartLink(c *gin.Context) {
+	email := c.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	name := c.Query("provider")
+	if name == "" {
+		errors.BadRequestResponse(c, "provider is required")
+		return
+	}
+
+	authURL, state, ok := h.begin(c, name, email)
+	if !ok {
+		return
+	}
+
+	responses.JSONData(c, http.StatusOK, gin.H{
+		"state":             state,
+		"authorization_url": authURL,
+	})
+}
+
+func (h *OAuthHandler) Callback(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		errors.UnauthorizedResponse(c, "could not receive `code` from authorizing party")
+		return
+	}
+
+	state := c.Query("state")
+	if state == "" {
+		errors.UnauthorizedResponse(c, "could not receive `state` from authorizing party")
+		return
+	}
+
+	binding, _ := c.Cookie(oauthStateCookie)
+	// the state cookie is only needed once, whatever the outcome
+	c.SetCookie(oauthStateCookie, "", -1, oauthStateCookiePath, "", false, true)
+
+	pending, err := h.authSvc.FinishOAuth(c, provider.Name(), state, binding)
+	if err != nil {
+		if cerror.Is(err, services.ErrInvalidState) {
+			errors.UnauthorizedResponse(c, "invalid state")
+		} else {
+			errors.InternalServerErrorResponse(c, "could not validate state")
+		}
+		return
+	}
+
+	ouser, err := provider.Authenticate(c, code, pending.AuthRequest)
+	if err != nil {
+		log.Printf("%s login failed: %v", provider.Name(), err)
+		errors.UnauthorizedResponse(c, "could not authenticate with "+provider.Name())
+		return
+	}
+
+	redirectTo := h.frontendURL
+	if target, ok := h.returnTo.Resolve(pending.ReturnTo); ok {
+		redirectTo = target
+	}
+
+	completeOAuth(c, h.identitySvc, h.frontendURL, redirectTo, pending.LinkEmail, ouser)
+}
+
+// begin stores a new login with the named provider and returns where to
+// send the user. It writes the error response itself when ok is false.
+func (h *OAuthHandler) begin(c *gin.Context, name string, linkEmail string) (authURL string, state string, ok bool) {
+	provider, exists := h.providers[name]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return "", "", false
+	}
+
+	req, binding, err := h.authSvc.BeginOAuth(c, services.OAuthLogin{
+		Provider:  provider.Name(),
+		LinkEmail: linkEmail,
+		ReturnTo:  c.Query("return_to"),
+	})
+	if err != nil {
+		log.Printf("could not store %s login state: %v", name, err)
+		errors.ServiceUnavailableResponse(c, "failed to store state")
+		return "", "", false
+	}
+
+	authURL, err = provider.AuthCodeURL(c, req)
+	if err != nil {
+		log.Printf("could not build %s authorization url: %v", name, err)
+		errors.ServiceUnavailableResponse(c, "provider unavailable")
+		return "", "", false
+	}
+
+	// a browser only has one login in flight, starting another one replaces it
+	c.SetCookie(oauthStateCookie, binding, int(services.OAuthStateTTL.Seconds()), oauthStateCookiePath, "", false, true)
+
+	return authURL, req.State, true
+}
+
+// completeOAuth finishes a provider callback and sends the user on to
+// redirectTo. With linkEmail set the identity is linked to that user,
+// otherwise the identity signs in.
+func completeOAuth(c *gin.Context, identitySvc services.IdentityService, frontendURL string, redirectTo string, linkEmail string, ouser oauth.OAuthUser) {
+	if linkEmail != "" {
+		if err := identitySvc.Link(c, linkEmail, ouser); err != nil {
+			if cerror.Is(err, services.ErrIdentityLinkedElsewhere) {
+				errors.ConflictResponse(c, err.Error())
+			} else {
+				errors.InternalServerErrorResponse(c, "failed to link account")
+			}
+			return
+		}
+		responses.Redirect(c, redirectTo)
+		return
+	}
+
+	loginResp, err := identitySvc.SignIn(c, ouser, clientInfo(c))
+	if err != nil {
+		if cerror.Is(err, services.ErrIdentityLinkedElsewhere) {
+			errors.ConflictResponse(c, err.Error())
+		} else if cerror.Is(err, services.ErrAccountDisabled) {
+			errors.ForbiddenResponse(c, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(c, "failed to generate session")
+		}
+		return
+	}
+
+	if loginResp.LinkToken != "" {
+		// the owner of the existing account has to log in and confirm the link
+		q := url.Values{}
+		q.Set("link_token", loginResp.LinkToken)
+		q.Set("provider", ouser.Provider)
+		if redirectTo != frontendURL {
+			q.Set("return_to", redirectTo)
+		}
+		responses.Redirect(c, frontendURL+"/link-account?"+q.Encode())
+		return
+	}
+
+	c.SetCookie(
+		"refresh_token",
+		loginResp.RefreshToken,
+		int(jwttypes.RefreshTokenDuration),
+		jwttypes.CookiePath,
+		"",
+		false,
+		true,
+	)
+
+	c.SetCookie(
+		"jwt",
+		loginResp.AccessToken,
+		int(jwttypes.AccessTokenDuration),
+		jwttypes.CookiePath,
+		"",
+		false,
+		true,
+	)
+	responses.Redirect(c, redirectTo)
+}
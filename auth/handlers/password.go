@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	error "errors"
+	"net/http"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordHandler struct {
+	passwordService services.PasswordService
+}
+
+func NewPasswordHandler(passwordService services.PasswordService) *PasswordHandler {
+	return &PasswordHandler{
+		passwordService: passwordService,
+	}
+}
+
+func (h *PasswordHandler) Forgot(ctx *gin.Context) {
+	var req types.ForgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.passwordService.Forgot(ctx, req.Email); err != nil {
+		if error.Is(err, services.ErrTooManyRequests) {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "too many reset emails, try again later"})
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not send reset email")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "if the account exists, a reset email was sent")
+}
+
+func (h *PasswordHandler) Reset(ctx *gin.Context) {
+	var req types.ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.passwordService.Reset(ctx, req.Token, req.Password); err != nil {
+		if error.Is(err, errors.ErrInvalidToken) {
+			errors.BadRequestResponse(ctx, "invalid or expired reset link")
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not reset password")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "password reset")
+}
+
+func (h *PasswordHandler) Change(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "unauthorized")
+		return
+	}
+
+	var req types.ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.passwordService.Change(ctx, email, req.CurrentPassword, req.NewPassword, clientInfo(ctx)); err != nil {
+		if error.Is(err, errors.ErrInvalidCredentials) {
+			errors.BadRequestResponse(ctx, "current password is incorrect")
+		} else if error.Is(err, services.ErrLoginLocked) {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not change password")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "password changed")
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	cerror "errors"
+	"log"
+	"net/http"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/gin-gonic/gin"
+)
+
+type SessionHandler struct {
+	authService services.AuthService
+}
+
+func NewSessionHandler(authService services.AuthService) *SessionHandler {
+	return &SessionHandler{
+		authService: authService,
+	}
+}
+
+func (h *SessionHandler) List(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	sessions, err := h.authService.ListSessions(ctx, email, ctx.GetString("session_id"))
+	if err != nil {
+		log.Printf("could not list sessions of %s: %v", email, err)
+		errors.InternalServerErrorResponse(ctx, "could not list sessions")
+		return
+	}
+
+	responses.JSONData(ctx, http.StatusOK, sessions)
+}
+
+func (h *SessionHandler) Revoke(ctx *gin.Context) {
+	email := ctx.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(ctx, "user not authenticated")
+		return
+	}
+
+	id := ctx.Param("id")
+	if err := h.authService.RevokeSession(ctx, email, id); err != nil {
+		if cerror.Is(err, store.ErrSessionNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		} else {
+			log.Printf("could not revoke session %s of %s: %v", id, email, err)
+			errors.InternalServerErrorResponse(ctx, "could not revoke session")
+		}
+		return
+	}
+
+	if id == ctx.GetString("session_id") {
+		ctx.SetCookie("jwt", "", -1, jwttypes.CookiePath, "", false, true)
+		ctx.SetCookie("refresh_token", "", -1, jwttypes.CookiePath, "", false, true)
+	}
+	responses.JSONSuccess(ctx, "session revoked")
+}
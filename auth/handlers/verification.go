@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	error "errors"
+	"net/http"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/gin-gonic/gin"
+)
+
+type VerificationHandler struct {
+	verificationService services.VerificationService
+}
+
+func NewVerificationHandler(verificationService services.VerificationService) *VerificationHandler {
+	return &VerificationHandler{
+		verificationService: verificationService,
+	}
+}
+
+func (h *VerificationHandler) Verify(ctx *gin.Context) {
+	var req types.VerifyEmailRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.verificationService.Verify(ctx, req.Token); err != nil {
+		if error.Is(err, errors.ErrInvalidToken) || error.Is(err, errors.ErrInvalidTokenType) || error.Is(err, errors.ErrUserNotFound) {
+			errors.BadRequestResponse(ctx, "invalid or expired verification link")
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not verify email")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "email verified, reset your password or use a sign in link to log in")
+}
+
+func (h *VerificationHandler) Resend(ctx *gin.Context) {
+	var req types.ResendVerificationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		errors.BadRequestResponse(ctx, err.Error())
+		return
+	}
+
+	if err := h.verificationService.SendVerification(ctx, req.Email); err != nil {
+		if error.Is(err, services.ErrTooManyRequests) {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "too many verification emails, try again later"})
+		} else {
+			errors.InternalServerErrorResponse(ctx, "could not send verification email")
+		}
+		return
+	}
+
+	responses.JSONSuccess(ctx, "if the account exists and is not verified yet, a verification email was sent")
+}
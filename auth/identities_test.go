@@ -0,0 +1,176 @@
+package auth_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Yulian302/lfusys-services-gateway/auth/oauth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryIdentityStore struct {
+	mu         sync.Mutex
+	identities map[string]types.Identity
+}
+
+func (s *memoryIdentityStore) Create(ctx context.Context, identity types.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := types.IdentityID(identity.Provider, identity.ProviderID)
+	if _, ok := s.identities[id]; ok {
+		return store.ErrIdentityExists
+	}
+	identity.ID = id
+	s.identities[id] = identity
+	return nil
+}
+
+func (s *memoryIdentityStore) Get(ctx context.Context, provider, providerID string) (*types.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	identity, ok := s.identities[types.IdentityID(provider, providerID)]
+	if !ok {
+		return nil, store.ErrIdentityNotFound
+	}
+	return &identity, nil
+}
+
+func (s *memoryIdentityStore) ListByEmail(ctx context.Context, email string) ([]types.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var identities []types.Identity
+	for _, identity := range s.identities {
+		if identity.UserEmail == email {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (s *memoryIdentityStore) Delete(ctx context.Context, email, provider, providerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := types.IdentityID(provider, providerID)
+	if identity, ok := s.identities[id]; !ok || identity.UserEmail != email {
+		return store.ErrIdentityNotFound
+	}
+	delete(s.identities, id)
+	return nil
+}
+
+func (s *memoryIdentityStore) IsReady(ctx context.Context) error { return nil }
+func (s *memoryIdentityStore) Name() string                      { return "memory" }
+
+// fakeOAuth records which users were logged in
+type fakeOAuth struct {
+	logins []string
+}
+
+func (f *fakeOAuth) LoginOAuth(ctx context.Context, email string, client types.ClientInfo) (*services.LoginResponse, error) {
+	f.logins = append(f.logins, email)
+	return &services.LoginResponse{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+func (f *fakeOAuth) RegisterOAuth(ctx context.Context, userData oauth.OAuthUser) (types.User, error) {
+	return types.User{RegisterUser: types.RegisterUser{Email: userData.Email}}, nil
+}
+
+func (f *fakeOAuth) BeginOAuth(ctx context.Context, login services.OAuthLogin) (oauth.AuthRequest, string, error) {
+	return oauth.AuthRequest{}, "", nil
+}
+
+func (f *fakeOAuth) FinishOAuth(ctx context.Context, provider string, state string, binding string) (*services.PendingOAuth, error) {
+	return nil, services.ErrInvalidState
+}
+
+func newIdentityService(t *testing.T) (*services.IdentityServiceImpl, *memoryIdentityStore, *fakeOAuth) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	identities := &memoryIdentityStore{identities: map[string]types.Identity{}}
+	authSvc := &fakeOAuth{}
+	svc := services.NewIdentityService(identities, mockStore, store.NewRedisTokenStore(rdb), authSvc, "secret")
+
+	return svc, identities, authSvc
+}
+
+var githubUser = oauth.OAuthUser{
+	Email:         "victim@gmail.com",
+	EmailVerified: true,
+	Provider:      "github",
+	ProviderID:    "42",
+}
+
+func TestOAuthSignIn_DoesNotMergeIntoPasswordAccount(t *testing.T) {
+	mockStore.ResetMock()
+	svc, identities, authSvc := newIdentityService(t)
+
+	mockStore.On(
+		"GetByEmail",
+		mock.Anything,
+		"victim@gmail.com",
+	).Return(
+		&types.User{
+			RegisterUser: types.RegisterUser{
+				Email:    "victim@gmail.com",
+				Password: "$argon2id$...",
+			},
+			Verified: true,
+		},
+		nil,
+	)
+
+	resp, err := svc.SignIn(context.Background(), githubUser, types.ClientInfo{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.AccessToken)
+	assert.NotEmpty(t, resp.LinkToken)
+	assert.Empty(t, authSvc.logins)
+	assert.Empty(t, identities.identities)
+
+	// only the account owner can confirm
+	err = svc.ConfirmLink(context.Background(), "attacker@gmail.com", resp.LinkToken)
+	assert.Error(t, err)
+
+	require.NoError(t, svc.ConfirmLink(context.Background(), "victim@gmail.com", resp.LinkToken))
+	assert.Error(t, svc.ConfirmLink(context.Background(), "victim@gmail.com", resp.LinkToken))
+
+	resp, err = svc.SignIn(context.Background(), githubUser, types.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "access", resp.AccessToken)
+	assert.Equal(t, []string{"victim@gmail.com"}, authSvc.logins)
+}
+
+func TestOAuthUnlink_KeepsLastLoginMethod(t *testing.T) {
+	mockStore.ResetMock()
+	svc, _, _ := newIdentityService(t)
+
+	mockStore.On(
+		"GetByEmail",
+		mock.Anything,
+		"oauth@gmail.com",
+	).Return(
+		&types.User{
+			RegisterUser: types.RegisterUser{
+				Email: "oauth@gmail.com",
+			},
+		},
+		nil,
+	)
+
+	ctx := context.Background()
+	require.NoError(t, svc.Link(ctx, "oauth@gmail.com", oauth.OAuthUser{Provider: "github", ProviderID: "1"}))
+	require.NoError(t, svc.Link(ctx, "oauth@gmail.com", oauth.OAuthUser{Provider: "google", ProviderID: "2"}))
+
+	assert.ErrorIs(t, svc.Link(ctx, "other@gmail.com", oauth.OAuthUser{Provider: "github", ProviderID: "1"}), services.ErrIdentityLinkedElsewhere)
+
+	require.NoError(t, svc.Unlink(ctx, "oauth@gmail.com", "github", "1"))
+	assert.ErrorIs(t, svc.Unlink(ctx, "oauth@gmail.com", "google", "2"), services.ErrLastLoginMethod)
+	assert.ErrorIs(t, svc.Unlink(ctx, "oauth@gmail.com", "github", "1"), store.ErrIdentityNotFound)
+}
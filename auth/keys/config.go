@@ -0,0 +1,55 @@
+package keys
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// KeyConfig is one entry of the JSON key configuration. The PEM is given
+// inline or as a file, e.g. a mounted secret.
+type KeyConfig struct {
+	ID         string    `json:"kid"`
+	PEM        string    `json:"pem"`
+	PEMFile    string    `json:"pem_file"`
+	ActiveFrom time.Time `json:"active_from"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Load reads a JSON list of KeyConfig
+func Load(data []byte) (*KeySet, error) {
+	var configs []KeyConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parse key config: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(configs))
+	for _, cfg := range configs {
+		pemData := []byte(cfg.PEM)
+		if cfg.PEMFile != "" {
+			var err error
+			pemData, err = os.ReadFile(cfg.PEMFile)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", cfg.ID, err)
+			}
+		}
+
+		key, err := ParseKey(cfg.ID, pemData, cfg.ActiveFrom, cfg.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(keys...)
+}
+
+// LoadFile reads the key configuration from path
+func LoadFile(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(data)
+}
@@ -0,0 +1,185 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// Key is an RSA or Ed25519 key. Keys without a private part only verify.
+type Key struct {
+	ID string
+	// the key signs new tokens from this time on, until a newer key takes over
+	ActiveFrom time.Time
+	// after this time tokens of the key are no longer accepted, zero for never
+	ExpiresAt time.Time
+
+	signer crypto.Signer
+	public crypto.PublicKey
+	method jwt.SigningMethod
+}
+
+// ParseKey reads a PKCS#8 or PKCS#1 private key, or a PKIX public key, from PEM
+func ParseKey(id string, pemData []byte, activeFrom, expiresAt time.Time) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("key id is required")
+	}
+
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM data", id)
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s: unsupported PEM type %q", id, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", id, err)
+	}
+
+	return NewKey(id, parsed, activeFrom, expiresAt)
+}
+
+func NewKey(id string, key any, activeFrom, expiresAt time.Time) (*Key, error) {
+	k := &Key{ID: id, ActiveFrom: activeFrom, ExpiresAt: expiresAt}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.signer, k.public, k.method = key, &key.PublicKey, jwt.SigningMethodRS256
+	case *rsa.PublicKey:
+		k.public, k.method = key, jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		k.signer, k.public, k.method = key, key.Public(), jwt.SigningMethodEdDSA
+	case ed25519.PublicKey:
+		k.public, k.method = key, jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", id, key)
+	}
+
+	if pub, ok := k.public.(*rsa.PublicKey); ok && pub.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("key %s: rsa keys need at least 2048 bits", id)
+	}
+	return k, nil
+}
+
+func (k *Key) usable(now time.Time) bool {
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
+
+// KeySet holds the current signing key and every key whose tokens are
+// still accepted. Rotation is scheduled through ActiveFrom: a new key is
+// published in the JWKS as soon as it is configured and signs once its
+// time has come, while older keys keep verifying until they expire.
+// Keys are loaded once at startup, so ActiveFrom is the only way to
+// schedule a rotation without a restart.
+type KeySet struct {
+	keys []*Key
+}
+
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if seen[k.ID] {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		seen[k.ID] = true
+	}
+
+	sorted := append([]*Key(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActiveFrom.After(sorted[j].ActiveFrom)
+	})
+	return &KeySet{keys: sorted}, nil
+}
+
+// Signer returns the newest active key with a private part
+func (s *KeySet) Signer(now time.Time) (*Key, error) {
+	if s != nil {
+		for _, k := range s.keys {
+			if k.signer != nil && !k.ActiveFrom.After(now) && k.usable(now) {
+				return k, nil
+			}
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+func (s *KeySet) Lookup(kid string, now time.Time) (*Key, bool) {
+	if s != nil {
+		for _, k := range s.keys {
+			if k.ID == kid && k.usable(now) {
+				return k, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (s *KeySet) Empty() bool {
+	return s == nil || len(s.keys) == 0
+}
+
+// JWK is the public part of a key as published in the JWKS
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes every key that is not expired, including keys that only
+// become active later, so verifiers know them before the first token
+func (s *KeySet) JWKS(now time.Time) JWKS {
+	set := JWKS{Keys: []JWK{}}
+	if s == nil {
+		return set
+	}
+
+	for _, k := range s.keys {
+		if !k.usable(now) {
+			continue
+		}
+		jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,130 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rsaKey(t *testing.T, id string, activeFrom time.Time) *Key {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := NewKey(id, priv, activeFrom, time.Time{})
+	require.NoError(t, err)
+	return key
+}
+
+func edKey(t *testing.T, id string, activeFrom time.Time) *Key {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := NewKey(id, priv, activeFrom, time.Time{})
+	require.NoError(t, err)
+	return key
+}
+
+func claims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "user@example.com", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func TestTokens_SignsWithNewestActiveKey(t *testing.T) {
+	now := time.Now()
+	old := rsaKey(t, "old", now.Add(-48*time.Hour))
+	current := edKey(t, "current", now.Add(-time.Hour))
+	next := rsaKey(t, "next", now.Add(24*time.Hour))
+
+	set, err := NewKeySet(old, next, current)
+	require.NoError(t, err)
+	tokens := Tokens{Keys: set}
+
+	signed, err := tokens.Sign(claims())
+	require.NoError(t, err)
+
+	parsed, err := tokens.Parse(signed, &jwt.RegisteredClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "current", parsed.Header["kid"])
+	assert.Equal(t, "EdDSA", parsed.Method.Alg())
+
+	// tokens of the previous key stay valid after the rotation
+	oldToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims())
+	oldToken.Header["kid"] = "old"
+	oldSigned, err := oldToken.SignedString(old.signer)
+	require.NoError(t, err)
+	_, err = tokens.Parse(oldSigned, &jwt.RegisteredClaims{})
+	assert.NoError(t, err)
+
+	kids := []string{}
+	for _, jwk := range set.JWKS(now).Keys {
+		kids = append(kids, jwk.Kid)
+	}
+	assert.ElementsMatch(t, []string{"old", "current", "next"}, kids)
+}
+
+func TestTokens_RejectsUnknownAndMismatchedKeys(t *testing.T) {
+	current := rsaKey(t, "current", time.Now().Add(-time.Hour))
+	set, err := NewKeySet(current)
+	require.NoError(t, err)
+	tokens := Tokens{Keys: set}
+
+	other := rsaKey(t, "other", time.Now())
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims())
+	token.Header["kid"] = "other"
+	signed, err := token.SignedString(other.signer)
+	require.NoError(t, err)
+	_, err = tokens.Parse(signed, &jwt.RegisteredClaims{})
+	assert.Error(t, err)
+
+	// HS256 with the public key as secret must not verify
+	pub, err := x509.MarshalPKIXPublicKey(current.public)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = "current"
+	forgedSigned, err := forged.SignedString(pub)
+	require.NoError(t, err)
+	_, err = tokens.Parse(forgedSigned, &jwt.RegisteredClaims{})
+	assert.Error(t, err)
+}
+
+func TestTokens_LegacySecret(t *testing.T) {
+	set, err := NewKeySet(rsaKey(t, "current", time.Now().Add(-time.Hour)))
+	require.NoError(t, err)
+
+	legacy, err := HS256("secret").Sign(claims())
+	require.NoError(t, err)
+
+	_, err = Tokens{Keys: set, Legacy: []byte("secret")}.Parse(legacy, &jwt.RegisteredClaims{})
+	assert.NoError(t, err)
+
+	_, err = Tokens{Keys: set}.Parse(legacy, &jwt.RegisteredClaims{})
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	config, err := json.Marshal([]KeyConfig{{
+		ID:         "2026-10",
+		PEM:        string(pemData),
+		ActiveFrom: time.Now().Add(-time.Hour),
+	}})
+	require.NoError(t, err)
+
+	set, err := Load(config)
+	require.NoError(t, err)
+
+	key, err := set.Signer(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10", key.ID)
+}
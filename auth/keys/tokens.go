@@ -0,0 +1,69 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Tokens signs and verifies one kind of token. Tokens are signed with the
+// key set and carry its kid. Legacy is the HS256 secret of tokens issued
+// before the key set, or of deployments without one; tokens without a
+// kid are only accepted while it is set.
+type Tokens struct {
+	Keys   *KeySet
+	Legacy []byte
+}
+
+// HS256 only signs and verifies with secret
+func HS256(secret string) Tokens {
+	return Tokens{Legacy: []byte(secret)}
+}
+
+func (t Tokens) Sign(claims jwt.Claims) (string, error) {
+	if t.Keys.Empty() {
+		if len(t.Legacy) == 0 {
+			return "", ErrNoSigningKey
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.Legacy)
+	}
+
+	key, err := t.Keys.Signer(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signer)
+}
+
+func (t Tokens) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	methods := []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+	if len(t.Legacy) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	return jwt.ParseWithClaims(tokenString, claims, t.keyfunc, jwt.WithValidMethods(methods))
+}
+
+func (t Tokens) keyfunc(token *jwt.Token) (any, error) {
+	kid, hasKid := token.Header["kid"].(string)
+	if !hasKid {
+		if len(t.Legacy) > 0 && token.Method == jwt.SigningMethodHS256 {
+			return t.Legacy, nil
+		}
+		return nil, errors.New("token has no key id")
+	}
+
+	key, ok := t.Keys.Lookup(kid, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("key %s does not sign %s", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
@@ -0,0 +1,57 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/test"
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/keys"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTMiddleware_AsymmetricKeys(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := keys.NewKey("2026-10", priv, time.Now().Add(-time.Minute), time.Time{})
+	require.NoError(t, err)
+	keySet, err := keys.NewKeySet(key)
+	require.NoError(t, err)
+
+	svc := services.NewAuthServiceImpl(mockStore, nil, nil, nil, cfg.JWTConfig.SecretKey, cfg.JWTConfig.RefreshSecretKey)
+	svc.Keys = keySet
+
+	pair, _, err := svc.GenerateTokenPair(&types.User{RegisterUser: types.RegisterUser{Email: "test@gmail.com"}}, services.TokenSession{AuthTime: time.Now().Unix()})
+	require.NoError(t, err)
+
+	engine := gin.New()
+	engine.GET("/me", auth.JWTMiddleware(svc.AccessTokens(), nil, nil), func(c *gin.Context) { c.String(http.StatusOK, c.GetString("email")) })
+
+	w := test.PerformRequest(engine, t, "GET", "/me", nil, []string{"Authorization: Bearer " + pair.AccessToken}, false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "test@gmail.com", w.Body.String())
+
+	// both token types share the key, neither passes as the other
+	_, err = svc.ValidateToken(pair.RefreshToken)
+	assert.ErrorIs(t, err, errors.ErrInvalidTokenType)
+	_, err = svc.ValidateRefreshToken(pair.AccessToken)
+	assert.ErrorIs(t, err, errors.ErrInvalidTokenType)
+
+	// HS256 tokens are refused once the keys are in place, unless still accepted
+	w = test.PerformRequest(engine, t, "GET", "/me", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	svc.AcceptLegacyTokens = true
+	engine = gin.New()
+	engine.GET("/me", auth.JWTMiddleware(svc.AccessTokens(), nil, nil), func(c *gin.Context) { c.String(http.StatusOK, c.GetString("email")) })
+	w = test.PerformRequest(engine, t, "GET", "/me", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
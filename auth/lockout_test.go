@@ -0,0 +1,240 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/ratelimit"
+	"github.com/Yulian302/lfusys-services-gateway/auth/password"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newLockoutService(t *testing.T) *services.AuthServiceImpl {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	svc := services.NewAuthServiceImpl(mockStore, nil, store.NewRedisTokenStore(rdb), nil, "access", "refresh")
+	svc.Lockout = services.NewLoginLockout(store.NewRedisLoginAttemptStore(rdb))
+	svc.Lockout.AccountIPThreshold = 3
+	svc.Lockout.AccountThreshold = 6
+	svc.Lockout.IPThreshold = 10
+	return svc
+}
+
+func TestLogin_LocksAccountAfterFailures(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	ctx := context.Background()
+
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+	mockStore.On("GetByEmail", mock.Anything, "target@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "target@gmail.com", Password: hashed}},
+		nil,
+	)
+
+	for range 3 {
+		_, err := svc.Login(ctx, "target@gmail.com", "wrong-password", types.ClientInfo{IP: "10.6.6.6"})
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	}
+
+	// even the right password is refused while locked
+	_, err = svc.Login(ctx, "TARGET@gmail.com", "password123", types.ClientInfo{IP: "10.6.6.6"})
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+
+	// but the owner can still log in from elsewhere
+	_, err = svc.Login(ctx, "target@gmail.com", "password123", types.ClientInfo{IP: "10.0.0.1"})
+	assert.NoError(t, err)
+}
+
+func TestLogin_LocksAccountGuessedFromManyIPs(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	ctx := context.Background()
+
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+	mockStore.On("GetByEmail", mock.Anything, "target@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "target@gmail.com", Password: hashed}},
+		nil,
+	)
+
+	for i := range 6 {
+		_, err := svc.Login(ctx, "target@gmail.com", "wrong-password", types.ClientInfo{IP: fmt.Sprintf("10.0.0.%d", i+1)})
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	}
+
+	_, err = svc.Login(ctx, "target@gmail.com", "password123", types.ClientInfo{IP: "10.0.0.9"})
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+}
+
+func TestLogin_UnknownAccountsLockLikeKnownOnes(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	ctx := context.Background()
+
+	mockStore.On("GetByEmail", mock.Anything, "nobody@gmail.com").Return(nil, errors.ErrUserNotFound)
+
+	for range 3 {
+		_, err := svc.Login(ctx, "nobody@gmail.com", "guess", types.ClientInfo{IP: "10.0.0.1"})
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	}
+
+	_, err := svc.Login(ctx, "nobody@gmail.com", "guess", types.ClientInfo{IP: "10.0.0.1"})
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+}
+
+func TestLogin_LocksSprayingIP(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	ctx := context.Background()
+
+	mockStore.On("GetByEmail", mock.Anything, mock.Anything).Return(nil, errors.ErrUserNotFound)
+
+	for i := range 10 {
+		_, err := svc.Login(ctx, fmt.Sprintf("user%d@gmail.com", i), "Summer2024!", types.ClientInfo{IP: "10.6.6.6"})
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	}
+
+	_, err := svc.Login(ctx, "fresh@gmail.com", "Summer2024!", types.ClientInfo{IP: "10.6.6.6"})
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+
+	_, err = svc.Login(ctx, "fresh@gmail.com", "Summer2024!", types.ClientInfo{IP: "10.0.0.1"})
+	assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+}
+
+func TestLogin_SuccessResetsAccountFailures(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	ctx := context.Background()
+
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+	mockStore.On("GetByEmail", mock.Anything, "user@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "user@gmail.com", Password: hashed}},
+		nil,
+	)
+
+	for range 2 {
+		_, err := svc.Login(ctx, "user@gmail.com", "wrong-password", types.ClientInfo{IP: "10.0.0.1"})
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	}
+	_, err = svc.Login(ctx, "user@gmail.com", "password123", types.ClientInfo{IP: "10.0.0.1"})
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err := svc.Login(ctx, "user@gmail.com", "wrong-password", types.ClientInfo{IP: "10.0.0.1"})
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	}
+	_, err = svc.Login(ctx, "user@gmail.com", "password123", types.ClientInfo{IP: "10.0.0.1"})
+	assert.NoError(t, err)
+}
+
+func TestReauthenticate_SharesLoginLockout(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	ctx := context.Background()
+
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+	mockStore.On("GetByEmail", mock.Anything, "target@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "target@gmail.com", Password: hashed}},
+		nil,
+	)
+
+	client := types.ClientInfo{IP: "10.6.6.6"}
+	for range 3 {
+		err := svc.Reauthenticate(ctx, "target@gmail.com", "wrong-password", "", 0, client)
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	}
+
+	err = svc.Reauthenticate(ctx, "target@gmail.com", "password123", "", 0, client)
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+	_, err = svc.Login(ctx, "target@gmail.com", "password123", client)
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+}
+
+func TestPasswordChange_SharesLoginLockout(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	passwords := services.NewPasswordService(mockStore, nil, nil, nil, svc, nil, nil, "http://frontend")
+	ctx := context.Background()
+
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+	mockStore.On("GetByEmail", mock.Anything, "target@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "target@gmail.com", Password: hashed}},
+		nil,
+	)
+
+	client := types.ClientInfo{IP: "10.6.6.6"}
+	for range 3 {
+		err := passwords.Change(ctx, "target@gmail.com", "wrong-password", "newpassword", client)
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	}
+
+	err = passwords.Change(ctx, "target@gmail.com", "password123", "newpassword", client)
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+	mockStore.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func newMFAUser(t *testing.T, email string) {
+	hashed, err := password.Hash("password123")
+	require.NoError(t, err)
+	mockStore.On("GetByEmail", mock.Anything, email).Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: email, Password: hashed}, MFAEnabled: true, MFASecret: "JBSWY3DPEHPK3PXP"},
+		nil,
+	)
+	mockStore.On("ConsumeRecoveryCode", mock.Anything, email, mock.Anything).Return(store.ErrMFACodeUsed)
+}
+
+func TestMFA_AttemptsLimitedPerUserAcrossLogins(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	svc.Limiter = ratelimit.NewRedisRateLimiter(redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}))
+	ctx := context.Background()
+	newMFAUser(t, "target@gmail.com")
+
+	for i := range 6 {
+		// every password login hands out a fresh mfa token
+		client := types.ClientInfo{IP: fmt.Sprintf("10.0.0.%d", i+1)}
+		resp, err := svc.Login(ctx, "target@gmail.com", "password123", client)
+		require.NoError(t, err)
+
+		_, err = svc.VerifyMFA(ctx, resp.MFAToken, "not-a-code", client)
+		if i < 5 {
+			assert.ErrorIs(t, err, services.ErrInvalidMFACode)
+		} else {
+			assert.ErrorIs(t, err, services.ErrTooManyMFAAttempts)
+		}
+	}
+
+	// disabling mfa shares the budget
+	err := svc.DisableMFA(ctx, "target@gmail.com", "not-a-code", types.ClientInfo{IP: "10.9.9.9"})
+	assert.ErrorIs(t, err, services.ErrTooManyMFAAttempts)
+}
+
+func TestMFA_WrongCodesLockOut(t *testing.T) {
+	mockStore.ResetMock()
+	svc := newLockoutService(t)
+	ctx := context.Background()
+	newMFAUser(t, "target@gmail.com")
+
+	client := types.ClientInfo{IP: "10.6.6.6"}
+	for range 3 {
+		err := svc.DisableMFA(ctx, "target@gmail.com", "not-a-code", client)
+		assert.ErrorIs(t, err, services.ErrInvalidMFACode)
+	}
+
+	err := svc.DisableMFA(ctx, "target@gmail.com", "not-a-code", client)
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+	_, err = svc.Login(ctx, "target@gmail.com", "password123", client)
+	assert.ErrorIs(t, err, services.ErrLoginLocked)
+}
@@ -0,0 +1,126 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/test"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func requestMagicLink(t *testing.T, email string) string {
+	body, _ := json.Marshal(types.MagicLinkRequest{Email: email})
+	w := test.PerformRequest(r, t, "POST", "/auth/magic-link", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	sent := mailer.Sent()
+	msg := sent[len(sent)-1]
+	require.Equal(t, email, msg.To)
+
+	_, rest, found := strings.Cut(msg.Body, "token=")
+	require.True(t, found)
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	require.NoError(t, err)
+	return token
+}
+
+func verifyMagicLink(t *testing.T, token string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(types.VerifyMagicLinkRequest{Token: token})
+	return test.PerformRequest(r, t, "POST", "/auth/magic-link/verify", bytes.NewReader(body), []string{"Content-Type: application/json"}, false, "", "")
+}
+
+func TestMagicLink_CreatesUserOnFirstUse(t *testing.T) {
+	mockStore.ResetMock()
+
+	mockStore.On("GetByEmail", mock.Anything, "new@gmail.com").Return(nil, errors.ErrUserNotFound).Once()
+	mockStore.On("Create", mock.Anything, mock.MatchedBy(func(u types.User) bool {
+		return u.Email == "new@gmail.com" && u.Name == "new" && u.Verified && u.Password == ""
+	})).Return(nil)
+	mockStore.On("GetByEmail", mock.Anything, "new@gmail.com").Return(
+		&types.User{ID: "u1", RegisterUser: types.RegisterUser{Email: "new@gmail.com", Name: "new"}, Verified: true},
+		nil,
+	)
+
+	token := requestMagicLink(t, "new@gmail.com")
+	mockStore.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+
+	w := verifyMagicLink(t, token)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, responseCookie(w, "jwt"))
+	assert.NotEmpty(t, responseCookie(w, "refresh_token"))
+	mockStore.AssertExpectations(t)
+
+	// links are single-use
+	w = verifyMagicLink(t, token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestMagicLink_AsksForSecondFactor(t *testing.T) {
+	mockStore.ResetMock()
+
+	mockStore.On("GetByEmail", mock.Anything, "mfa@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "mfa@gmail.com"}, Verified: true, MFAEnabled: true},
+		nil,
+	)
+
+	w := verifyMagicLink(t, requestMagicLink(t, "mfa@gmail.com"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, responseCookie(w, "jwt"))
+	assert.NotEmpty(t, dataField(t, w, "mfa_token"))
+}
+
+func TestMagicLink_TakesOverUnverifiedAccount(t *testing.T) {
+	mockStore.ResetMock()
+	ctx := context.Background()
+
+	// registered by someone who never proved the address
+	mockStore.On("GetByEmail", mock.Anything, "victim@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "victim@gmail.com", Password: "attacker-hash"}, MFAEnabled: true, MFASecret: "attacker-secret"},
+		nil,
+	).Once()
+	mockStore.On("Update", mock.Anything, "victim@gmail.com", mock.MatchedBy(func(u store.UserUpdate) bool {
+		return *u.Verified && *u.Password == "" && !*u.MFAEnabled && *u.MFASecret == "" && len(*u.MFARecoveryCodes) == 0
+	})).Return(nil)
+	mockStore.On("GetByEmail", mock.Anything, "victim@gmail.com").Return(
+		&types.User{RegisterUser: types.RegisterUser{Email: "victim@gmail.com"}, Verified: true},
+		nil,
+	)
+	takeoverAPIKeys.keys["attacker-key"] = types.APIKey{ID: "attacker-key", UserEmail: "victim@gmail.com"}
+	takeoverIdentities.identities["github#666"] = types.Identity{Provider: "github", ProviderID: "666", UserEmail: "victim@gmail.com"}
+	before, err := tokenStore.Generation(ctx, "victim@gmail.com")
+	require.NoError(t, err)
+
+	w := verifyMagicLink(t, requestMagicLink(t, "victim@gmail.com"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, responseCookie(w, "jwt"))
+	mockStore.AssertExpectations(t)
+
+	after, err := tokenStore.Generation(ctx, "victim@gmail.com")
+	require.NoError(t, err)
+	assert.Greater(t, after, before, "sessions of the earlier registrant are ended")
+	assert.NotContains(t, takeoverAPIKeys.keys, "attacker-key")
+	assert.NotContains(t, takeoverIdentities.identities, "github#666")
+
+	// the new session belongs to the new generation
+	w = test.PerformRequest(r, t, "GET", "/auth/sessions", nil, sessionCookies(w), false, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMagicLink_InvalidToken(t *testing.T) {
+	mockStore.ResetMock()
+
+	w := verifyMagicLink(t, "not-a-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockStore.AssertNotCalled(t, "GetByEmail", mock.Anything, mock.Anything)
+}
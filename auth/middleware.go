@@ -1,27 +1,69 @@
 package auth
 
 import (
+	"context"
+	cerr "errors"
+	"log"
+	"slices"
+	"strings"
+
 	"github.com/Yulian302/lfusys-services-commons/errors"
-	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-gateway/auth/keys"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func JWTMiddleware(secretKey string) gin.HandlerFunc {
+// RevocationChecker reports whether an otherwise valid token was revoked
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, claims *types.Claims) (bool, error)
+}
+
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*types.APIKey, *types.User, error)
+}
+
+func JWTMiddleware(tokens keys.Tokens, revocations RevocationChecker, apiKeys APIKeyAuthenticator) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		token, err := ctx.Cookie("jwt")
-		if err != nil || token == "" {
+		token, bearer, err := AccessToken(ctx)
+		if err != nil {
+			errors.UnauthorizedResponse(ctx, err.Error())
+			ctx.Abort()
+			return
+		}
+		if token == "" {
 			errors.UnauthorizedResponse(ctx, "unauthorized")
 			ctx.Abort()
 			return
 		}
 
-		parsedToken, err := jwt.ParseWithClaims(token, &jwttypes.JWTClaims{}, func(t *jwt.Token) (any, error) {
-			return []byte(secretKey), nil
-		})
+		if bearer && apiKeys != nil && strings.HasPrefix(token, types.APIKeyPrefix) {
+			apiKey, owner, err := apiKeys.Authenticate(ctx, token)
+			if err != nil {
+				if cerr.Is(err, errors.ErrInvalidToken) {
+					errors.UnauthorizedResponse(ctx, "invalid_api_key")
+				} else {
+					log.Printf("could not authenticate api key: %v", err)
+					errors.ServiceUnavailableResponse(ctx, "could not verify api key")
+				}
+				ctx.Abort()
+				return
+			}
+
+			ctx.Set("email", apiKey.UserEmail)
+			ctx.Set("email_verified", owner.Verified)
+			// so that uploads with a key get the owner's limits
+			ctx.Set("roles", owner.RoleNames())
+			ctx.Set("api_key_id", apiKey.ID)
+			ctx.Set("scopes", apiKey.Scopes)
+			ctx.Next()
+			return
+		}
+
+		parsedToken, err := tokens.Parse(token, &types.Claims{})
 		if err != nil || !parsedToken.Valid {
 			refresh, _ := ctx.Cookie("refresh_token")
-			if refresh != "" {
+			if (bearer && cerr.Is(err, jwt.ErrTokenExpired)) || (!bearer && refresh != "") {
 				errors.UnauthorizedResponse(ctx, "token_expired")
 			} else {
 				errors.UnauthorizedResponse(ctx, "invalid_token")
@@ -30,14 +72,86 @@ func JWTMiddleware(secretKey string) gin.HandlerFunc {
 			return
 		}
 
-		claims := parsedToken.Claims.(*jwttypes.JWTClaims)
+		claims := parsedToken.Claims.(*types.Claims)
 		if claims.Type != "access" {
 			errors.UnauthorizedResponse(ctx, "invalid token type")
 			ctx.Abort()
 			return
 		}
 
+		if revocations != nil {
+			revoked, err := revocations.IsRevoked(ctx, claims)
+			if err != nil {
+				log.Printf("could not check token revocation: %v", err)
+				errors.ServiceUnavailableResponse(ctx, "could not verify session")
+				ctx.Abort()
+				return
+			}
+			if revoked {
+				errors.UnauthorizedResponse(ctx, "token_revoked")
+				ctx.Abort()
+				return
+			}
+		}
+
 		ctx.Set("email", claims.Subject)
+		ctx.Set("email_verified", claims.Verified)
+		ctx.Set("roles", claims.Roles)
+		ctx.Set("auth_time", claims.AuthTime)
+		ctx.Set("session_id", claims.SessionID)
+		ctx.Next()
+	}
+}
+
+// RequireScope only restricts API key requests; browser and bearer
+// sessions act with the full rights of their user.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		scopes, isAPIKey := ctx.Get("scopes")
+		if isAPIKey && !slices.Contains(scopes.([]string), scope) {
+			errors.ForbiddenResponse(ctx, "api key lacks scope "+scope)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireRole lets only users with role through. Roles come from the
+// access token or the API key's owner, routes no key may reach also need
+// RequireSession.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !slices.Contains(ctx.GetStringSlice("roles"), role) {
+			errors.ForbiddenResponse(ctx, "requires role "+role)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireSession rejects API key requests, e.g. for managing the keys themselves
+func RequireSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, isAPIKey := ctx.Get("api_key_id"); isAPIKey {
+			errors.ForbiddenResponse(ctx, "not allowed with an api key")
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireVerified rejects users that did not verify their email address yet.
+// A disabled check lets every request through.
+func RequireVerified(enabled bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if enabled && !ctx.GetBool("email_verified") {
+			errors.ForbiddenResponse(ctx, "email not verified")
+			ctx.Abort()
+			return
+		}
 		ctx.Next()
 	}
 }
@@ -24,12 +24,37 @@ func NewGithubProvider(cfg *config.GithubConfig) *githubProvider {
 	}
 }
 
-func (p *githubProvider) ExchangeCode(ctx context.Context, code string) (string, error) {
+func (p *githubProvider) Name() string {
+	return types.Providers[types.GithubProvider]
+}
+
+func (p *githubProvider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	q := url.Values{}
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURI)
+	q.Set("scope", "read:user user:email")
+	q.Set("state", req.State)
+	q.Set("code_challenge", req.CodeChallenge())
+	q.Set("code_challenge_method", "S256")
+
+	return "https://github.com/login/oauth/authorize?" + q.Encode(), nil
+}
+
+func (p *githubProvider) Authenticate(ctx context.Context, code string, req AuthRequest) (OAuthUser, error) {
+	token, err := p.ExchangeCode(ctx, code, req.CodeVerifier)
+	if err != nil {
+		return OAuthUser{}, err
+	}
+	return p.GetOAuthUser(ctx, token)
+}
+
+func (p *githubProvider) ExchangeCode(ctx context.Context, code string, codeVerifier string) (string, error) {
 	data := url.Values{}
 	data.Set("client_id", p.cfg.ClientID)
 	data.Set("client_secret", p.cfg.ClientSecret)
 	data.Set("code", code)
 	data.Set("redirect_uri", p.cfg.RedirectURI)
+	data.Set("code_verifier", codeVerifier)
 
 	var resp struct {
 		AccessToken string `json:"access_token"`
@@ -64,15 +89,26 @@ func (p *githubProvider) GetOAuthUser(ctx context.Context, token string) (OAuthU
 		Username:   ghUser.Login,
 	}
 
-	if user.Email == "" {
-		var emails []struct {
-			Email    string `json:"email"`
-			Primary  bool   `json:"primary"`
-			Verified bool   `json:"verified"`
-		}
-		if err := p.client.GetJSONWithToken(ctx, "https://api.github.com/user/emails", token, &emails); err != nil {
-			return OAuthUser{}, err
+	// the public profile email is not necessarily verified, so always check the email list
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.client.GetJSONWithToken(ctx, "https://api.github.com/user/emails", token, &emails); err != nil {
+		return OAuthUser{}, err
+	}
+
+	if user.Email != "" {
+		for _, e := range emails {
+			if e.Email == user.Email {
+				user.EmailVerified = e.Verified
+				break
+			}
 		}
+	}
+	if !user.EmailVerified {
+		user.Email = ""
 		for _, e := range emails {
 			if e.Primary && e.Verified {
 				user.Email = e.Email
@@ -90,6 +126,7 @@ func (p *githubProvider) GetOAuthUser(ctx context.Context, token string) (OAuthU
 		if user.Email == "" {
 			return OAuthUser{}, fmt.Errorf("no verified email found")
 		}
+		user.EmailVerified = true
 	}
 
 	return user, nil
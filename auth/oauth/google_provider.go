@@ -23,12 +23,39 @@ func NewGoogleProvider(cfg *config.GoogleConfig) *googleProvider {
 	}
 }
 
-func (p *googleProvider) ExchangeCode(ctx context.Context, code string) (string, error) {
+func (p *googleProvider) Name() string {
+	return types.Providers[types.GoogleProvider]
+}
+
+func (p *googleProvider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	q := url.Values{}
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURI)
+	q.Set("response_type", "code")
+	q.Set("scope", "openid email profile")
+	q.Set("state", req.State)
+	q.Set("code_challenge", req.CodeChallenge())
+	q.Set("code_challenge_method", "S256")
+	q.Set("nonce", req.Nonce)
+
+	return "https://accounts.google.com/o/oauth2/v2/auth?" + q.Encode(), nil
+}
+
+func (p *googleProvider) Authenticate(ctx context.Context, code string, req AuthRequest) (OAuthUser, error) {
+	token, err := p.ExchangeCode(ctx, code, req.CodeVerifier)
+	if err != nil {
+		return OAuthUser{}, err
+	}
+	return p.GetOAuthUser(ctx, token)
+}
+
+func (p *googleProvider) ExchangeCode(ctx context.Context, code string, codeVerifier string) (string, error) {
 	data := url.Values{}
 	data.Set("client_id", p.cfg.ClientID)
 	data.Set("client_secret", p.cfg.ClientSecret)
 	data.Set("code", code)
 	data.Set("redirect_uri", p.cfg.RedirectURI)
+	data.Set("code_verifier", codeVerifier)
 	data.Set("grant_type", "authorization_code")
 
 	var resp struct {
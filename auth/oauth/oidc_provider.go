@@ -0,0 +1,344 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	discoveryTTL = time.Hour
+	// unknown key ids trigger a JWKS refetch, but not more often than this
+	jwksMinRefresh = time.Minute
+	idTokenLeeway  = time.Minute
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+type OIDCConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scopes       []string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+}
+
+// oidcProvider logs in against any OpenID Connect issuer. Endpoints come
+// from the issuer's discovery document and ID tokens are checked against
+// its published keys.
+type oidcProvider struct {
+	cfg    OIDCConfig
+	client *auth.Client
+
+	mu           sync.Mutex
+	discovery    *oidcDiscovery
+	discoveredAt time.Time
+	keys         map[string]crypto.PublicKey
+	keysFetched  time.Time
+}
+
+func NewOIDCProvider(cfg OIDCConfig) *oidcProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+
+	return &oidcProvider{
+		cfg:    cfg,
+		client: auth.NewClient(10 * time.Second),
+	}
+}
+
+func (p *oidcProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURI)
+	q.Set("response_type", "code")
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", req.State)
+	q.Set("nonce", req.Nonce)
+	q.Set("code_challenge", req.CodeChallenge())
+	q.Set("code_challenge_method", "S256")
+
+	return d.AuthorizationEndpoint + "?" + q.Encode(), nil
+}
+
+func (p *oidcProvider) Authenticate(ctx context.Context, code string, req AuthRequest) (OAuthUser, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return OAuthUser{}, err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", p.cfg.RedirectURI)
+	data.Set("client_id", p.cfg.ClientID)
+	data.Set("client_secret", p.cfg.ClientSecret)
+	data.Set("code_verifier", req.CodeVerifier)
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		ErrorDesc   string `json:"error_description"`
+	}
+	if err := p.client.PostFormJSON(ctx, d.TokenEndpoint, data, &resp); err != nil {
+		return OAuthUser{}, err
+	}
+	if resp.Error != "" {
+		return OAuthUser{}, fmt.Errorf("%s error: %s - %s", p.cfg.Name, resp.Error, resp.ErrorDesc)
+	}
+	if resp.IDToken == "" {
+		return OAuthUser{}, fmt.Errorf("%w: missing in token response", ErrInvalidIDToken)
+	}
+
+	claims, err := p.verifyIDToken(ctx, d, resp.IDToken, req.Nonce)
+	if err != nil {
+		return OAuthUser{}, err
+	}
+
+	// some issuers only put the profile into the userinfo response
+	if claims.Email == "" && d.UserinfoEndpoint != "" && resp.AccessToken != "" {
+		var info idTokenClaims
+		if err := p.client.GetJSONWithToken(ctx, d.UserinfoEndpoint, resp.AccessToken, &info); err != nil {
+			return OAuthUser{}, err
+		}
+		if info.Subject != claims.Subject {
+			return OAuthUser{}, fmt.Errorf("%w: userinfo subject mismatch", ErrInvalidIDToken)
+		}
+		claims.Email = info.Email
+		claims.EmailVerified = info.EmailVerified
+		if claims.Name == "" {
+			claims.Name = info.Name
+		}
+	}
+	if claims.Email == "" {
+		return OAuthUser{}, fmt.Errorf("%s response missing email", p.cfg.Name)
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Name
+	}
+
+	return OAuthUser{
+		Name:          claims.Name,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Provider:      p.cfg.Name,
+		ProviderID:    claims.Subject,
+		AvatarURL:     claims.Picture,
+		Username:      username,
+	}, nil
+}
+
+func (p *oidcProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, idToken string, nonce string) (*idTokenClaims, error) {
+	token, err := jwt.ParseWithClaims(
+		idToken,
+		&idTokenClaims{},
+		func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			return p.key(ctx, d, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(idTokenLeeway),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	claims := token.Claims.(*idTokenClaims)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, fmt.Errorf("%w: azp mismatch", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := p.client.GetJSONWithToken(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", "", &d); err != nil {
+		if p.discovery != nil {
+			// keep using the last known document while the issuer is unreachable
+			return p.discovery, nil
+		}
+		return nil, fmt.Errorf("oidc discovery of %s: %w", p.cfg.Issuer, err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery of %s: issuer mismatch %q", p.cfg.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery of %s: incomplete document", p.cfg.Issuer)
+	}
+
+	p.discovery = &d
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// key returns the signing key with the given id, refetching the JWKS once
+// when the issuer rotated its keys
+func (p *oidcProvider) key(ctx context.Context, d *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetched) < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.client.GetJSONWithToken(ctx, d.JWKSURI, "", &set); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookupKey falls back to the only key of the set for tokens without a kid
+func (p *oidcProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{
+			"P-256": elliptic.P256(),
+			"P-384": elliptic.P384(),
+			"P-521": elliptic.P521(),
+		}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,217 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubIssuer is a minimal OpenID Connect issuer whose token endpoint
+// answers with whatever id token the test prepared
+type stubIssuer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	key        *rsa.PrivateKey
+	kid        string
+	idToken    string
+	jwksServed int
+	// code_verifier of the last token request
+	verifier string
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	s := &stubIssuer{key: key, kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.jwksServed++
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": s.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.verifier = r.PostFormValue("code_verifier")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     s.idToken,
+		})
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stubIssuer) issue(t *testing.T, claims jwt.MapClaims) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+
+	signed, err := token.SignedString(s.key)
+	require.NoError(t, err)
+
+	s.mu.Lock()
+	s.idToken = signed
+	s.mu.Unlock()
+}
+
+func (s *stubIssuer) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            s.URL,
+		"sub":            "user-1",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "user@corp.example",
+		"email_verified": true,
+		"name":           "Corp User",
+	}
+}
+
+func newTestOIDCProvider(issuer *stubIssuer) *oidcProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Name:         "corp",
+		Issuer:       issuer.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURI:  "http://gateway/auth/corp/callback",
+	})
+}
+
+func newTestAuthRequest(t *testing.T) AuthRequest {
+	req, err := NewAuthRequest()
+	require.NoError(t, err)
+	return req
+}
+
+func TestOIDC_Authenticate(t *testing.T) {
+	issuer := newStubIssuer(t)
+	p := newTestOIDCProvider(issuer)
+	req := newTestAuthRequest(t)
+
+	authURL, err := p.AuthCodeURL(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(authURL, issuer.URL+"/authorize?"))
+	assert.Contains(t, authURL, "nonce="+req.Nonce)
+	assert.Contains(t, authURL, "code_challenge="+req.CodeChallenge())
+	assert.Contains(t, authURL, "code_challenge_method=S256")
+
+	issuer.issue(t, issuer.claims(req.Nonce))
+
+	user, err := p.Authenticate(context.Background(), "code", req)
+	require.NoError(t, err)
+	assert.Equal(t, "corp", user.Provider)
+	assert.Equal(t, "user-1", user.ProviderID)
+	assert.Equal(t, "user@corp.example", user.Email)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, req.CodeVerifier, issuer.verifier)
+}
+
+func TestAuthRequest_CodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	req := AuthRequest{CodeVerifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"}
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", req.CodeChallenge())
+}
+
+func TestOIDC_RejectsInvalidIDTokens(t *testing.T) {
+	issuer := newStubIssuer(t)
+	p := newTestOIDCProvider(issuer)
+	req := newTestAuthRequest(t)
+
+	cases := map[string]func(jwt.MapClaims){
+		"nonce":    func(c jwt.MapClaims) { c["nonce"] = "other-nonce" },
+		"audience": func(c jwt.MapClaims) { c["aud"] = "other-client" },
+		"expired":  func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+	}
+
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			claims := issuer.claims(req.Nonce)
+			tamper(claims)
+			issuer.issue(t, claims)
+
+			_, err := p.Authenticate(context.Background(), "code", req)
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("signature", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, issuer.claims(req.Nonce))
+		token.Header["kid"] = issuer.kid
+		signed, err := token.SignedString(other)
+		require.NoError(t, err)
+		issuer.mu.Lock()
+		issuer.idToken = signed
+		issuer.mu.Unlock()
+
+		_, err = p.Authenticate(context.Background(), "code", req)
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+}
+
+func TestOIDC_CachesKeysAndFollowsRotation(t *testing.T) {
+	issuer := newStubIssuer(t)
+	p := newTestOIDCProvider(issuer)
+	req := newTestAuthRequest(t)
+
+	for range 3 {
+		issuer.issue(t, issuer.claims(req.Nonce))
+		_, err := p.Authenticate(context.Background(), "code", req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, issuer.jwksServed)
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer.mu.Lock()
+	issuer.key = newKey
+	issuer.kid = "key-2"
+	issuer.mu.Unlock()
+
+	// pretend the cached set is old enough to be refetched
+	p.keysFetched = time.Now().Add(-2 * jwksMinRefresh)
+
+	issuer.issue(t, issuer.claims(req.Nonce))
+	_, err = p.Authenticate(context.Background(), "code", req)
+	require.NoError(t, err)
+	assert.Equal(t, 2, issuer.jwksServed)
+}
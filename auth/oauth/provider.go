@@ -1,6 +1,11 @@
 package oauth
 
-import "context"
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
 
 type OAuthUser struct {
 	Name          string
@@ -16,7 +21,47 @@ const (
 	OAuthPrefix = "oauth:state:"
 )
 
+// AuthRequest is what a login has to carry from the redirect to the
+// provider over to its callback
+type AuthRequest struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// NewAuthRequest generates a fresh state, nonce and PKCE verifier
+func NewAuthRequest() (AuthRequest, error) {
+	var values [3]string
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return AuthRequest{}, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	return AuthRequest{
+		State:        values[0],
+		Nonce:        values[1],
+		CodeVerifier: values[2],
+	}, nil
+}
+
+// CodeChallenge is the S256 PKCE challenge of the request's verifier
+func (r AuthRequest) CodeChallenge() string {
+	sum := sha256.Sum256([]byte(r.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
 type Provider interface {
-	ExchangeCode(ctx context.Context, code string) (accessToken string, err error)
-	GetOAuthUser(ctx context.Context, token string) (OAuthUser, error)
+	Name() string
+	AuthCodeURL(ctx context.Context, req AuthRequest) (string, error)
+	Authenticate(ctx context.Context, code string, req AuthRequest) (OAuthUser, error)
+}
+
+// Registry holds the configured providers by name
+type Registry map[string]Provider
+
+func (r Registry) Register(p Provider) {
+	r[p.Name()] = p
 }
@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOAuthStateService(t *testing.T) *services.AuthServiceImpl {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	return services.NewAuthServiceImpl(mockStore, store.NewRedisStoreImpl(rdb), store.NewRedisTokenStore(rdb), nil, "access", "refresh")
+}
+
+func TestOAuthState_RoundTripIsSingleUse(t *testing.T) {
+	svc := newOAuthStateService(t)
+	ctx := context.Background()
+
+	req, binding, err := svc.BeginOAuth(ctx, services.OAuthLogin{Provider: "github"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, req.Nonce)
+	assert.NotEmpty(t, req.CodeVerifier)
+
+	pending, err := svc.FinishOAuth(ctx, "github", req.State, binding)
+	require.NoError(t, err)
+	assert.Equal(t, req, pending.AuthRequest)
+	assert.Empty(t, pending.LinkEmail)
+
+	_, err = svc.FinishOAuth(ctx, "github", req.State, binding)
+	assert.ErrorIs(t, err, services.ErrInvalidState)
+}
+
+func TestOAuthState_RejectsOtherProvider(t *testing.T) {
+	svc := newOAuthStateService(t)
+	ctx := context.Background()
+
+	req, binding, err := svc.BeginOAuth(ctx, services.OAuthLogin{Provider: "github", LinkEmail: "user@example.com", ReturnTo: "/files/42"})
+	require.NoError(t, err)
+
+	_, err = svc.FinishOAuth(ctx, "google", req.State, binding)
+	assert.ErrorIs(t, err, services.ErrInvalidState)
+}
+
+func TestOAuthState_KeepsLogin(t *testing.T) {
+	svc := newOAuthStateService(t)
+	ctx := context.Background()
+
+	login := services.OAuthLogin{Provider: "github", LinkEmail: "user@example.com", ReturnTo: "/files/42"}
+	req, binding, err := svc.BeginOAuth(ctx, login)
+	require.NoError(t, err)
+
+	pending, err := svc.FinishOAuth(ctx, "github", req.State, binding)
+	require.NoError(t, err)
+	assert.Equal(t, login, pending.OAuthLogin)
+}
+
+func TestOAuthState_BoundToBrowser(t *testing.T) {
+	svc := newOAuthStateService(t)
+	ctx := context.Background()
+
+	victim, _, err := svc.BeginOAuth(ctx, services.OAuthLogin{Provider: "github"})
+	require.NoError(t, err)
+	_, attackerBinding, err := svc.BeginOAuth(ctx, services.OAuthLogin{Provider: "github"})
+	require.NoError(t, err)
+
+	_, err = svc.FinishOAuth(ctx, "github", victim.State, "")
+	assert.ErrorIs(t, err, services.ErrInvalidState)
+	_, err = svc.FinishOAuth(ctx, "github", victim.State, attackerBinding)
+	assert.ErrorIs(t, err, services.ErrInvalidState)
+	_, err = svc.FinishOAuth(ctx, "github", victim.State, victim.State+".forged")
+	assert.ErrorIs(t, err, services.ErrInvalidState)
+}
+
+func TestOAuthState_FailsClosedWhenStoreIsDown(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	svc := services.NewAuthServiceImpl(mockStore, store.NewRedisStoreImpl(rdb), store.NewRedisTokenStore(rdb), nil, "access", "refresh")
+	ctx := context.Background()
+
+	req, binding, err := svc.BeginOAuth(ctx, services.OAuthLogin{Provider: "github"})
+	require.NoError(t, err)
+
+	server.Close()
+
+	_, err = svc.FinishOAuth(ctx, "github", req.State, binding)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, services.ErrInvalidState)
+
+	_, _, err = svc.BeginOAuth(ctx, services.OAuthLogin{Provider: "github"})
+	assert.Error(t, err)
+}
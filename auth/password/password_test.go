@@ -0,0 +1,74 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Yulian302/lfusys-services-commons/crypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash_RoundTrip(t *testing.T) {
+	encoded, err := Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=19456,t=2,p=1$"))
+
+	ok, rehash, err := Verify("password123", encoded, "")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = Verify("wrong-password", encoded, "")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerify_LegacyHashNeedsRehash(t *testing.T) {
+	hashed, salt := crypt.HashSHA256WithSalt("password123")
+
+	ok, rehash, err := Verify("password123", hashed, salt)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, rehash, err = Verify("wrong-password", hashed, salt)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, rehash)
+}
+
+func TestVerify_OutdatedParamsNeedRehash(t *testing.T) {
+	weaker := DefaultParams
+	weaker.Iterations = 1
+
+	encoded, err := hashWithParams("password123", weaker)
+	require.NoError(t, err)
+
+	ok, rehash, err := Verify("password123", encoded, "")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestVerify_MalformedHash(t *testing.T) {
+	_, _, err := Verify("password123", "$argon2id$v=19$garbage", "")
+	assert.ErrorIs(t, err, ErrInvalidHash)
+}
+
+func TestVerify_RejectsDegenerateParams(t *testing.T) {
+	salt := "c2FsdHNhbHRzYWx0c2FsdA"
+	key := "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=19456,t=2,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=0,t=2,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=19456,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=19456,t=2,p=1$$" + key,
+		"$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$",
+	} {
+		ok, _, err := Verify("any-password", encoded, "")
+		assert.ErrorIs(t, err, ErrInvalidHash, encoded)
+		assert.False(t, ok, encoded)
+	}
+}
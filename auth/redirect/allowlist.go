@@ -0,0 +1,106 @@
+package redirect
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Allowlist decides where users may be sent back to after logging in.
+// Entries are origins, which allow every path, or origins with a path,
+// which allow that path and everything below it.
+type Allowlist struct {
+	base    *url.URL
+	entries []*url.URL
+}
+
+// NewAllowlist resolves relative return_to values against base and always
+// allows the origin of base
+func NewAllowlist(base string, entries []string) (*Allowlist, error) {
+	baseURL, err := parseAbsolute(base)
+	if err != nil {
+		return nil, fmt.Errorf("base %q: %w", base, err)
+	}
+
+	a := &Allowlist{
+		base:    baseURL,
+		entries: []*url.URL{{Scheme: baseURL.Scheme, Host: baseURL.Host}},
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		u, err := parseAbsolute(entry)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", entry, err)
+		}
+		a.entries = append(a.entries, &url.URL{Scheme: u.Scheme, Host: u.Host, Path: cleanPath(u.Path)})
+	}
+	return a, nil
+}
+
+// Resolve returns the absolute URL to redirect to, or false if returnTo is
+// empty or not allowed
+func (a *Allowlist) Resolve(returnTo string) (string, bool) {
+	if a == nil || returnTo == "" || strings.ContainsAny(returnTo, "\\\r\n\t") {
+		return "", false
+	}
+
+	u, err := url.Parse(returnTo)
+	if err != nil || u.User != nil || u.Opaque != "" {
+		return "", false
+	}
+	if !u.IsAbs() {
+		// only paths on the frontend, "//host" would leave it
+		if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+			return "", false
+		}
+		u = a.base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	u.Path = cleanPath(u.Path)
+	u.RawPath = ""
+
+	for _, entry := range a.entries {
+		if entry.Scheme == u.Scheme && strings.EqualFold(entry.Host, u.Host) && underPath(u.Path, entry.Path) {
+			return u.String(), true
+		}
+	}
+	return "", false
+}
+
+func parseAbsolute(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("not an absolute http(s) url")
+	}
+	return u, nil
+}
+
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func underPath(p, prefix string) bool {
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	if p == strings.TrimSuffix(prefix, "/") {
+		return true
+	}
+	return strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/")
+}
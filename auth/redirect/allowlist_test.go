@@ -0,0 +1,55 @@
+package redirect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowlist_Resolve(t *testing.T) {
+	a, err := NewAllowlist("https://app.example.com", []string{
+		"https://share.example.com/files/",
+	})
+	require.NoError(t, err)
+
+	allowed := map[string]string{
+		"/files/abc?x=1":                     "https://app.example.com/files/abc?x=1",
+		"https://app.example.com/settings":   "https://app.example.com/settings",
+		"https://APP.example.com/":           "https://APP.example.com/",
+		"https://share.example.com/files":    "https://share.example.com/files",
+		"https://share.example.com/files/42": "https://share.example.com/files/42",
+	}
+	for in, want := range allowed {
+		got, ok := a.Resolve(in)
+		assert.True(t, ok, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	rejected := []string{
+		"",
+		"//evil.example.com/files",
+		"https://evil.example.com/",
+		"http://app.example.com/",
+		"https://app.example.com.evil.example/",
+		"https://user@app.example.com/",
+		"https://share.example.com/admin",
+		"https://share.example.com/filesystem",
+		"https://share.example.com/files/../admin",
+		"javascript:alert(1)",
+		"/\\evil.example.com",
+		"relative/path",
+	}
+	for _, in := range rejected {
+		_, ok := a.Resolve(in)
+		assert.False(t, ok, in)
+	}
+}
+
+func TestAllowlist_RejectsInvalidEntries(t *testing.T) {
+	_, err := NewAllowlist("https://app.example.com", []string{"/files"})
+	assert.Error(t, err)
+
+	_, err = NewAllowlist("app.example.com", nil)
+	assert.Error(t, err)
+}
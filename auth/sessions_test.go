@@ -0,0 +1,133 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Yulian302/lfusys-services-commons/test"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listSessions(t *testing.T, cookies []string) []types.Session {
+	w := test.PerformRequest(r, t, "GET", "/auth/sessions", nil, cookies, false, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data []types.Session `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Data
+}
+
+func currentSession(t *testing.T, sessions []types.Session) types.Session {
+	for _, s := range sessions {
+		if s.Current {
+			return s
+		}
+	}
+	t.Fatal("no current session")
+	return types.Session{}
+}
+
+func sessionCookies(w *httptest.ResponseRecorder) []string {
+	return []string{"Cookie: jwt=" + responseCookie(w, "jwt")}
+}
+
+func TestSessions_ListedAfterLogin(t *testing.T) {
+	mockStore.ResetMock()
+
+	cookies := sessionCookies(login(t))
+	current := currentSession(t, listSessions(t, cookies))
+
+	assert.NotEmpty(t, current.ID)
+	assert.False(t, current.CreatedAt.IsZero())
+	assert.False(t, current.LastUsedAt.IsZero())
+}
+
+func TestSessions_LongUserAgentCutOnRune(t *testing.T) {
+	mockStore.ResetMock()
+	login(t)
+
+	body, _ := json.Marshal(types.LoginUser{Email: "test@gmail.com", Password: "password123"})
+	w := test.PerformRequest(r, t, "POST", "/auth/login", bytes.NewReader(body), []string{
+		"Content-Type: application/json",
+		"User-Agent: a" + strings.Repeat("ü", 200),
+	}, false, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	current := currentSession(t, listSessions(t, sessionCookies(w)))
+	assert.True(t, utf8.ValidString(current.UserAgent))
+	assert.Equal(t, "a"+strings.Repeat("ü", 127), current.UserAgent)
+}
+
+func TestSessions_RevokeEndsSession(t *testing.T) {
+	mockStore.ResetMock()
+
+	other := login(t)
+	otherRefresh := responseCookie(other, "refresh_token")
+	otherID := currentSession(t, listSessions(t, sessionCookies(other))).ID
+
+	cookies := sessionCookies(login(t))
+	w := test.PerformRequest(r, t, "DELETE", "/auth/sessions/"+otherID, nil, cookies, false, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for _, s := range listSessions(t, cookies) {
+		assert.NotEqual(t, otherID, s.ID)
+	}
+	assert.Equal(t, http.StatusConflict, refresh(t, otherRefresh).Code)
+
+	// the access token of the revoked session is denied right away
+	w = test.PerformRequest(r, t, "GET", "/auth/sessions", nil, sessionCookies(other), false, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestSessions_RevokeDeniesOlderAccessTokens(t *testing.T) {
+	mockStore.ResetMock()
+
+	before := login(t)
+	otherID := currentSession(t, listSessions(t, sessionCookies(before))).ID
+	after := refresh(t, responseCookie(before, "refresh_token"))
+	require.Equal(t, http.StatusOK, after.Code)
+
+	w := test.PerformRequest(r, t, "DELETE", "/auth/sessions/"+otherID, nil, sessionCookies(login(t)), false, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// both access tokens of the session are still unexpired
+	for _, cookies := range [][]string{sessionCookies(before), sessionCookies(after)} {
+		w = test.PerformRequest(r, t, "GET", "/auth/sessions", nil, cookies, false, "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestSessions_RefreshUpdatesSession(t *testing.T) {
+	mockStore.ResetMock()
+
+	w := login(t)
+	before := currentSession(t, listSessions(t, sessionCookies(w)))
+
+	w = refresh(t, responseCookie(w, "refresh_token"))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	after := currentSession(t, listSessions(t, sessionCookies(w)))
+	assert.Equal(t, before.ID, after.ID)
+	assert.False(t, after.LastUsedAt.Before(before.LastUsedAt))
+}
+
+func TestSessions_CannotRevokeOthersSession(t *testing.T) {
+	mockStore.ResetMock()
+
+	id := currentSession(t, listSessions(t, sessionCookies(login(t)))).ID
+
+	w := test.PerformRequest(r, t, "DELETE", "/auth/sessions/"+id, nil, adminLogin(t), false, "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = test.PerformRequest(r, t, "DELETE", "/auth/sessions/unknown", nil, adminLogin(t), false, "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
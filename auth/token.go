@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrMalformedAuthHeader = errors.New("malformed authorization header")
+
+// AccessToken returns the access token of the request. An Authorization
+// header always wins over the jwt cookie, so clients that send explicit
+// credentials are never silently authenticated by a stale cookie.
+func AccessToken(ctx *gin.Context) (token string, bearer bool, err error) {
+	header := ctx.GetHeader("Authorization")
+	if header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", true, ErrMalformedAuthHeader
+		}
+		return strings.TrimSpace(token), true, nil
+	}
+
+	token, _ = ctx.Cookie("jwt")
+	return token, false, nil
+}
@@ -0,0 +1,24 @@
+package types
+
+import (
+	"time"
+
+	filetypes "github.com/Yulian302/lfusys-services-gateway/files/types"
+)
+
+// AccountExport is everything stored about a user, as handed out by the data export
+type AccountExport struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	Profile    UserProfile       `json:"profile"`
+	Identities []Identity        `json:"identities"`
+	APIKeys    []APIKey          `json:"api_keys"`
+	Files      []*filetypes.File `json:"files"`
+}
+
+// DeleteAccountRequest re-authenticates the deletion. Without a password
+// the session must come from a recent login.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	// required with the password when mfa is enabled
+	Code string `json:"code"`
+}
@@ -0,0 +1,11 @@
+package types
+
+type UserList struct {
+	Users []UserProfile `json:"users"`
+	// pass as cursor to get the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type SetRolesRequest struct {
+	Roles []string `json:"roles" binding:"required,min=1"`
+}
@@ -0,0 +1,36 @@
+package types
+
+import "time"
+
+const (
+	APIKeyPrefix = "lfk_"
+
+	ScopeUploadsWrite = "uploads:write"
+	ScopeFilesRead    = "files:read"
+)
+
+var Scopes = []string{
+	ScopeUploadsWrite,
+	ScopeFilesRead,
+}
+
+type APIKey struct {
+	ID         string     `json:"id" dynamodbav:"id"`
+	UserEmail  string     `json:"-" dynamodbav:"user_email"`
+	Name       string     `json:"name" dynamodbav:"name"`
+	Scopes     []string   `json:"scopes" dynamodbav:"scopes,stringset"`
+	KeyHash    string     `json:"-" dynamodbav:"key_hash"`
+	CreatedAt  time.Time  `json:"created_at" dynamodbav:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at" dynamodbav:"last_used_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=64"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+}
+
+// CreatedAPIKey is the only response that ever contains the plain key
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,16 @@
+package types
+
+import jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+
+// Claims extends the shared JWT claims with gateway specific fields
+type Claims struct {
+	jwttypes.JWTClaims
+	// per-user token generation, bumped on "log out everywhere"
+	Generation int64 `json:"gen,omitempty"`
+	Verified   bool  `json:"email_verified,omitempty"`
+	// only set on access tokens
+	Roles     []string `json:"roles,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	// when the user last entered their credentials, kept across refreshes
+	AuthTime int64 `json:"auth_time,omitempty"`
+}
@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+// Identity links an external login (provider, provider_id) to a user
+type Identity struct {
+	ID         string    `json:"-" dynamodbav:"id"`
+	Provider   string    `json:"provider" dynamodbav:"provider"`
+	ProviderID string    `json:"provider_id" dynamodbav:"provider_id"`
+	UserEmail  string    `json:"-" dynamodbav:"user_email"`
+	Email      string    `json:"email" dynamodbav:"email"`
+	LinkedAt   time.Time `json:"linked_at" dynamodbav:"linked_at"`
+}
+
+func IdentityID(provider, providerID string) string {
+	return provider + "#" + providerID
+}
+
+type ConfirmLinkRequest struct {
+	LinkToken string `json:"link_token" binding:"required"`
+}
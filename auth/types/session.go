@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+// ClientInfo describes where a login or refresh comes from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is a login on one device, i.e. a refresh token family
+type Session struct {
+	ID         string    `json:"id"`
+	UserEmail  string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// set for the session of the listing request
+	Current bool `json:"current"`
+}
@@ -1,5 +1,15 @@
 package types
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var Roles = []string{
+	RoleUser,
+	RoleAdmin,
+}
+
 type User struct {
 	ID   string `json:"id" dynamodbav:"id"`
 	Salt string `json:"-" dynamodbav:"salt"`
@@ -7,6 +17,59 @@ type User struct {
 	OAuthProvider string
 	OAuthID       string
 	Verified      bool
+
+	// profile fields the user can edit, the name being the display name
+	AvatarURL string `json:"avatar_url,omitempty" dynamodbav:"avatar_url,omitempty"`
+	Locale    string `json:"locale,omitempty" dynamodbav:"locale,omitempty"`
+	Timezone  string `json:"timezone,omitempty" dynamodbav:"timezone,omitempty"`
+
+	// users without stored roles only have RoleUser
+	Roles    []string `json:"roles" dynamodbav:"roles,stringset,omitempty"`
+	Disabled bool     `json:"disabled" dynamodbav:"disabled,omitempty"`
+
+	// mfa state is never sent to clients nor cached
+	MFAEnabled       bool     `json:"-" dynamodbav:"mfa_enabled"`
+	MFASecret        string   `json:"-" dynamodbav:"mfa_secret,omitempty"`
+	MFARecoveryCodes []string `json:"-" dynamodbav:"mfa_recovery_codes,stringset,omitempty"`
+}
+
+// RoleNames returns the roles of the user, RoleUser if none are stored
+func (u *User) RoleNames() []string {
+	if len(u.Roles) == 0 {
+		return []string{RoleUser}
+	}
+	return u.Roles
+}
+
+// UserProfile is the account as shown to its owner and to admins
+type UserProfile struct {
+	ID            string   `json:"id"`
+	Email         string   `json:"email"`
+	Name          string   `json:"name"`
+	AvatarURL     string   `json:"avatar_url,omitempty"`
+	Locale        string   `json:"locale,omitempty"`
+	Timezone      string   `json:"timezone,omitempty"`
+	Verified      bool     `json:"verified"`
+	OAuthProvider string   `json:"oauth_provider,omitempty"`
+	MFAEnabled    bool     `json:"mfa_enabled"`
+	Roles         []string `json:"roles"`
+	Disabled      bool     `json:"disabled"`
+}
+
+func NewUserProfile(u *User) UserProfile {
+	return UserProfile{
+		ID:            u.ID,
+		Email:         u.Email,
+		Name:          u.Name,
+		AvatarURL:     u.AvatarURL,
+		Locale:        u.Locale,
+		Timezone:      u.Timezone,
+		Verified:      u.Verified,
+		OAuthProvider: u.OAuthProvider,
+		MFAEnabled:    u.MFAEnabled,
+		Roles:         u.RoleNames(),
+		Disabled:      u.Disabled,
+	}
 }
 
 type RegisterUser struct {
@@ -18,17 +81,50 @@ type RegisterUser struct {
 type LoginUser struct {
 	Email    string `json:"email" dynamodbav:"email" binding:"required,email"`
 	Password string `json:"password" dynamodbav:"password" binding:"required,min=6"`
+	// return tokens in the response body instead of cookies
+	ReturnTokens bool `json:"return_tokens" dynamodbav:"-"`
+	// where the browser continues after logging in, if allowed
+	ReturnTo string `json:"return_to" dynamodbav:"-"`
 }
 
 type MeResponse struct {
-	Email         string `json:"email"`
+	Email string `json:"email"`
+	// the display name, under the key older clients read
 	Name          string `json:"username"`
 	Authenticated bool   `json:"authenticated"`
+	DisplayName   string `json:"display_name"`
+	AvatarURL     string `json:"avatar_url"`
+	Locale        string `json:"locale"`
+	Timezone      string `json:"timezone"`
+}
+
+func NewMeResponse(u *User) MeResponse {
+	return MeResponse{
+		Email:         u.Email,
+		Name:          u.Name,
+		Authenticated: true,
+		DisplayName:   u.Name,
+		AvatarURL:     u.AvatarURL,
+		Locale:        u.Locale,
+		Timezone:      u.Timezone,
+	}
+}
+
+// UpdateProfileRequest changes the fields that are set. An empty avatar
+// URL, locale or timezone removes it.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" binding:"omitnil,max=100"`
+	AvatarURL   *string `json:"avatar_url" binding:"omitnil,max=2048"`
+	Locale      *string `json:"locale" binding:"omitnil,max=35"`
+	Timezone    *string `json:"timezone" binding:"omitnil,max=64"`
 }
 
 // used for caching user info
 type PublicUser struct {
-	ID    string `json:"id"`
-	Email string `json:"email"`
-	Name  string `json:"name"`
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	Locale    string `json:"locale,omitempty"`
+	Timezone  string `json:"timezone,omitempty"`
 }
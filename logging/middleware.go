@@ -56,3 +56,22 @@ func LoggerMiddleware(baseLogger *slog.Logger) gin.HandlerFunc {
 
 	}
 }
+
+// FromContext returns the logger of the current request, or the default
+// logger outside of a request
+func FromContext(ctx context.Context) *slog.Logger {
+	// gin only looks into the request context with ContextWithFallback
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithLogger returns a context FromContext takes logger from, for work
+// outside of a request such as background jobs
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
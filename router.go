@@ -10,11 +10,14 @@ import (
 	"github.com/Yulian302/lfusys-services-commons/logger"
 	"github.com/Yulian302/lfusys-services-commons/ratelimit"
 	"github.com/Yulian302/lfusys-services-commons/responses"
+	"github.com/Yulian302/lfusys-services-gateway/auth"
 	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/Yulian302/lfusys-services-gateway/auth/redirect"
 	"github.com/Yulian302/lfusys-services-gateway/files"
 	"github.com/Yulian302/lfusys-services-gateway/logging"
 	"github.com/Yulian302/lfusys-services-gateway/middleware"
 	"github.com/Yulian302/lfusys-services-gateway/routers"
+	"github.com/Yulian302/lfusys-services-gateway/settings"
 	"github.com/Yulian302/lfusys-services-gateway/uploads"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -42,7 +45,7 @@ func applyCors(r *gin.Engine, app *App) {
 	r.Use(cors.New(
 		cors.Config{
 			AllowOrigins:     origins,
-			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 			AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
 			AllowCredentials: true,
 		},
@@ -89,27 +92,100 @@ func registerRoutes(r *gin.Engine, app *App, s *Services) {
 		health.NewHealthHandler(
 			s.Stores.uploads,
 			s.Stores.users,
+			s.Stores.apiKeys,
+			s.Stores.identities,
 		),
 		r,
 	)
 
+	requireAuth := auth.JWTMiddleware(s.AccessTokens, s.Auth, s.APIKeys)
+	requireVerified := auth.RequireVerified(app.Settings.VerificationPolicy != settings.VerifyNone)
+
+	returnTo, err := redirect.NewAllowlist(app.Config.FrontendURL, app.Settings.ReturnToAllowlist)
+	if err != nil {
+		log.Fatalf("invalid RETURN_TO_ALLOWLIST: %v", err)
+	}
+	oauthHandler := handlers.NewOAuthHandler(app.Config.FrontendURL, returnTo, s.Auth, s.Identities, s.Providers)
+
+	routers.RegisterWellKnownRoutes(
+		handlers.NewJWKSHandler(s.Keys),
+		r,
+	)
+
 	routers.RegisterAuthRoutes(
-		handlers.NewAuthHandler(s.Auth),
-		handlers.NewGithubHandler(app.Config.FrontendURL, app.Config.GithubConfig, s.Auth, s.Stores.users, s.Providers.Github),
-		handlers.NewGoogleHandler(app.Config.FrontendURL, app.Config.GoogleConfig, s.Auth, s.Stores.users, s.Providers.Google),
-		app.Config.JWTConfig.SecretKey,
+		handlers.NewAuthHandler(s.Auth, s.Verification, returnTo),
+		oauthHandler,
+		requireAuth,
+		r,
+	)
+
+	routers.RegisterVerificationRoutes(
+		handlers.NewVerificationHandler(s.Verification),
+		r,
+	)
+
+	routers.RegisterIdentityRoutes(
+		handlers.NewIdentityHandler(s.Identities),
+		oauthHandler,
+		requireAuth,
+		r,
+	)
+
+	routers.RegisterMFARoutes(
+		handlers.NewMFAHandler(s.Auth, returnTo),
+		requireAuth,
+		r,
+	)
+
+	routers.RegisterMagicLinkRoutes(
+		handlers.NewMagicLinkHandler(s.MagicLinks, returnTo),
+		r,
+	)
+
+	routers.RegisterPasswordRoutes(
+		handlers.NewPasswordHandler(s.Password),
+		requireAuth,
+		r,
+	)
+
+	routers.RegisterAPIKeyRoutes(
+		handlers.NewAPIKeyHandler(s.APIKeys),
+		requireAuth,
+		requireVerified,
+		r,
+	)
+
+	uploadsHandler := uploads.NewUploadsHandler(s.Uploads)
+
+	routers.RegisterAccountRoutes(
+		handlers.NewAccountHandler(s.Account),
+		uploadsHandler,
+		requireAuth,
+		r,
+	)
+
+	routers.RegisterSessionRoutes(
+		handlers.NewSessionHandler(s.Auth),
+		requireAuth,
+		r,
+	)
+
+	routers.RegisterAdminRoutes(
+		handlers.NewAdminHandler(s.Admin),
+		requireAuth,
 		r,
 	)
 
 	routers.RegisterUploadsRoutes(
-		uploads.NewUploadsHandler(s.Uploads),
-		app.Config.JWTConfig.SecretKey,
+		uploadsHandler,
+		requireAuth,
+		requireVerified,
 		r,
 	)
 
 	routers.RegisterFileRoutes(
 		files.NewFileHandler(s.Files),
-		app.Config.JWTConfig.SecretKey,
+		requireAuth,
 		r,
 	)
 }
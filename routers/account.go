@@ -0,0 +1,18 @@
+package routers
+
+import (
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/Yulian302/lfusys-services-gateway/uploads"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAccountRoutes(h *handlers.AccountHandler, uploadsHandler *uploads.UploadsHandler, requireAuth gin.HandlerFunc, route *gin.Engine) {
+	me := route.Group("/auth/me")
+
+	me.Use(requireAuth, auth.RequireSession())
+	me.PATCH("", h.UpdateProfile)
+	me.GET("/export", h.Export)
+	me.DELETE("", h.Delete)
+	me.GET("/usage", uploadsHandler.Usage)
+}
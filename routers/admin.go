@@ -0,0 +1,19 @@
+package routers
+
+import (
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAdminRoutes(h *handlers.AdminHandler, requireAuth gin.HandlerFunc, route *gin.Engine) {
+	admin := route.Group("/admin")
+
+	admin.Use(requireAuth, auth.RequireSession(), auth.RequireRole(types.RoleAdmin))
+	admin.GET("/users", h.ListUsers)
+	admin.POST("/users/:email/disable", h.Disable)
+	admin.POST("/users/:email/enable", h.Enable)
+	admin.PUT("/users/:email/roles", h.SetRoles)
+	admin.POST("/users/:email/logout", h.Logout)
+}
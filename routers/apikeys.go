@@ -0,0 +1,16 @@
+package routers
+
+import (
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAPIKeyRoutes(h *handlers.APIKeyHandler, requireAuth gin.HandlerFunc, requireVerified gin.HandlerFunc, route *gin.Engine) {
+	keys := route.Group("/auth/api-keys")
+
+	keys.Use(requireAuth, auth.RequireSession())
+	keys.POST("", requireVerified, h.Create)
+	keys.GET("", h.List)
+	keys.DELETE("/:id", h.Revoke)
+}
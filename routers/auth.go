@@ -6,17 +6,18 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterAuthRoutes(jwt *handlers.AuthHandler, gh *handlers.GithubHandler, googleh *handlers.GoogleHandler, jwtSecret string, route *gin.Engine) {
+func RegisterAuthRoutes(jwt *handlers.AuthHandler, oauth *handlers.OAuthHandler, requireAuth gin.HandlerFunc, route *gin.Engine) {
 	auth := route.Group("/auth")
 
-	auth.GET("/me", authmid.JWTMiddleware(jwtSecret), jwt.Me)
+	auth.GET("/me", requireAuth, jwt.Me)
 	auth.POST("/register", jwt.Register)
 	auth.POST("/login", jwt.Login)
 	auth.POST("/refresh", jwt.Refresh)
 	auth.POST("/logout", jwt.Logout)
+	auth.POST("/logout/all", requireAuth, authmid.RequireSession(), jwt.LogoutAll)
 
 	// oauth2
-	auth.POST("/state", jwt.NewState)
-	auth.GET("/github/callback", gh.Callback)
-	auth.GET("/google/callback", googleh.Callback)
+	auth.POST("/state", oauth.NewState)
+	auth.GET("/:provider/login", oauth.Login)
+	auth.GET("/:provider/callback", oauth.Callback)
 }
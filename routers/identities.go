@@ -0,0 +1,17 @@
+package routers
+
+import (
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterIdentityRoutes(h *handlers.IdentityHandler, oauth *handlers.OAuthHandler, requireAuth gin.HandlerFunc, route *gin.Engine) {
+	identities := route.Group("/auth/identities")
+
+	identities.Use(requireAuth, auth.RequireSession())
+	identities.GET("", h.List)
+	identities.POST("/link", oauth.StartLink)
+	identities.POST("/link/confirm", h.ConfirmLink)
+	identities.DELETE("/:provider/:provider_id", h.Unlink)
+}
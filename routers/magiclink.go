@@ -0,0 +1,13 @@
+package routers
+
+import (
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterMagicLinkRoutes(h *handlers.MagicLinkHandler, route *gin.Engine) {
+	magicLink := route.Group("/auth/magic-link")
+
+	magicLink.POST("", h.Send)
+	magicLink.POST("/verify", h.Verify)
+}
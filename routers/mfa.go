@@ -0,0 +1,17 @@
+package routers
+
+import (
+	authmid "github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterMFARoutes(h *handlers.MFAHandler, requireAuth gin.HandlerFunc, route *gin.Engine) {
+	mfa := route.Group("/auth/mfa")
+
+	mfa.POST("/verify", h.Verify)
+
+	mfa.POST("/setup", requireAuth, authmid.RequireSession(), h.Setup)
+	mfa.POST("/confirm", requireAuth, authmid.RequireSession(), h.Confirm)
+	mfa.POST("/disable", requireAuth, authmid.RequireSession(), h.Disable)
+}
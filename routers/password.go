@@ -0,0 +1,15 @@
+package routers
+
+import (
+	authmid "github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterPasswordRoutes(h *handlers.PasswordHandler, requireAuth gin.HandlerFunc, route *gin.Engine) {
+	password := route.Group("/auth/password")
+
+	password.POST("/forgot", h.Forgot)
+	password.POST("/reset", h.Reset)
+	password.POST("/change", requireAuth, authmid.RequireSession(), h.Change)
+}
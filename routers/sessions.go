@@ -0,0 +1,15 @@
+package routers
+
+import (
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterSessionRoutes(h *handlers.SessionHandler, requireAuth gin.HandlerFunc, route *gin.Engine) {
+	sessions := route.Group("/auth/sessions")
+
+	sessions.Use(requireAuth, auth.RequireSession())
+	sessions.GET("", h.List)
+	sessions.DELETE("/:id", h.Revoke)
+}
@@ -2,14 +2,17 @@ package routers
 
 import (
 	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
 	"github.com/Yulian302/lfusys-services-gateway/uploads"
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterUploadsRoutes(h *uploads.UploadsHandler, jwtSecret string, route *gin.Engine) {
+func RegisterUploadsRoutes(h *uploads.UploadsHandler, requireAuth gin.HandlerFunc, requireVerified gin.HandlerFunc, route *gin.Engine) {
 	uploads := route.Group("/uploads")
 
-	uploads.Use(auth.JWTMiddleware(jwtSecret))
+	uploads.Use(requireAuth, requireVerified, auth.RequireScope(types.ScopeUploadsWrite))
+	uploads.GET("", h.ListUploads)
 	uploads.POST("/start", h.StartUpload)
 	uploads.GET("/:uploadId/status", h.GetUploadStatus)
+	uploads.DELETE("/:uploadId", h.CancelUpload)
 }
@@ -0,0 +1,13 @@
+package routers
+
+import (
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterVerificationRoutes(h *handlers.VerificationHandler, route *gin.Engine) {
+	verify := route.Group("/auth/verify")
+
+	verify.POST("", h.Verify)
+	verify.POST("/resend", h.Resend)
+}
@@ -0,0 +1,12 @@
+package routers
+
+import (
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterWellKnownRoutes(h *handlers.JWKSHandler, route *gin.Engine) {
+	wellKnown := route.Group("/.well-known")
+
+	wellKnown.GET("/jwks.json", h.JWKS)
+}
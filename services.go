@@ -7,8 +7,12 @@ import (
 
 	pb "github.com/Yulian302/lfusys-services-commons/api"
 	"github.com/Yulian302/lfusys-services-commons/caching"
+	"github.com/Yulian302/lfusys-services-commons/ratelimit"
+	"github.com/Yulian302/lfusys-services-gateway/auth/keys"
 	"github.com/Yulian302/lfusys-services-gateway/auth/oauth"
+	"github.com/Yulian302/lfusys-services-gateway/mail"
 	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/settings"
 	"github.com/Yulian302/lfusys-services-gateway/store"
 	"github.com/sony/gobreaker/v2"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
@@ -17,24 +21,39 @@ import (
 )
 
 type Stores struct {
-	users    store.UserStore
-	sessions store.SessionStore
-	uploads  store.UploadsStore
-}
-
-type Providers struct {
-	Github oauth.Provider
-	Google oauth.Provider
+	users      store.UserStore
+	sessions   store.SessionStore
+	tokens     store.TokenStore
+	oneTime    store.OneTimeTokenStore
+	uploads    store.UploadsStore
+	apiKeys    store.APIKeyStore
+	identities store.IdentityStore
+	logins     store.LoginAttemptStore
+	quotas     store.QuotaStore
+	activity   store.UploadActivityStore
 }
 
 type Services struct {
-	Auth    services.AuthService
-	Uploads services.UploadsService
-	Files   services.FileService
+	Auth         services.AuthService
+	APIKeys      services.APIKeyService
+	Verification services.VerificationService
+	Password     services.PasswordService
+	MagicLinks   services.MagicLinkService
+	Identities   services.IdentityService
+	Admin        services.AdminService
+	Account      services.AccountService
+	Uploads      services.UploadsService
+	Files        services.FileService
+
+	UploadReaper *services.UploadReaper
 
 	Stores *Stores
 
-	Providers *Providers
+	Providers oauth.Registry
+
+	// verifies access tokens, shared with the JWT middleware
+	AccessTokens keys.Tokens
+	Keys         *keys.KeySet
 
 	Conn *grpc.ClientConn
 }
@@ -55,14 +74,34 @@ func BuildServices(app *App) *Services {
 
 	usrStore := store.NewUserStore(app.DynamoDB, app.Config.DynamoDBConfig.UsersTableName)
 	sessStore := store.NewRedisStoreImpl(app.Redis)
+	tokenStore := store.NewRedisTokenStore(app.Redis)
+	oneTimeStore := store.NewRedisOneTimeTokenStore(app.Redis)
 	upStore := store.NewUploadsStore(app.DynamoDB, app.Config.DynamoDBConfig.UploadsTableName)
+	apiKeyStore := store.NewAPIKeyStore(app.DynamoDB, app.Settings.APIKeysTableName)
+	identityStore := store.NewIdentityStore(app.DynamoDB, app.Settings.IdentitiesTableName)
+	loginStore := store.NewRedisLoginAttemptStore(app.Redis)
+	quotaStore := store.NewRedisQuotaStore(app.Redis)
+	activityStore := store.NewRedisUploadActivityStore(app.Redis)
 	clientStub := pb.NewUploaderClient(conn)
 
-	githubProvider := oauth.NewGithubProvider(app.Config.GithubConfig)
-	googleProvider := oauth.NewGoogleProvider(app.Config.GoogleConfig)
+	providers := buildProviders(app)
 
 	cacheSvc := caching.NewRedisCachingService(app.Redis)
-	authSvc := services.NewAuthServiceImpl(usrStore, sessStore, cacheSvc, app.Config.JWTConfig.SecretKey, app.Config.JWTConfig.RefreshSecretKey)
+	limiter := ratelimit.NewRedisRateLimiter(app.Redis)
+	authSvc := services.NewAuthServiceImpl(usrStore, sessStore, tokenStore, cacheSvc, app.Config.JWTConfig.SecretKey, app.Config.JWTConfig.RefreshSecretKey)
+	authSvc.RequireVerifiedLogin = app.Settings.VerificationPolicy == settings.VerifyLogin
+	authSvc.Limiter = limiter
+	authSvc.Lockout = services.NewLoginLockout(loginStore)
+	authSvc.Keys = buildKeySet(app.Settings)
+	authSvc.AcceptLegacyTokens = app.Settings.JWTAcceptLegacyTokens
+	apiKeySvc := services.NewAPIKeyService(apiKeyStore, usrStore)
+	identitySvc := services.NewIdentityService(identityStore, usrStore, tokenStore, authSvc, app.Config.JWTConfig.SecretKey)
+	adminSvc := services.NewAdminService(usrStore, authSvc)
+
+	mailer := buildMailer(app.Settings)
+	verificationSvc := services.NewVerificationService(usrStore, apiKeyStore, identityStore, authSvc, mailer, limiter, app.Config.JWTConfig.SecretKey, app.Config.FrontendURL)
+	passwordSvc := services.NewPasswordService(usrStore, apiKeyStore, identityStore, oneTimeStore, authSvc, mailer, limiter, app.Config.FrontendURL)
+	magicLinkSvc := services.NewMagicLinkService(usrStore, apiKeyStore, identityStore, oneTimeStore, authSvc, mailer, limiter, app.Config.FrontendURL)
 
 	uploadsBreaker := gobreaker.NewCircuitBreaker[*pb.UploadReply](gobreaker.Settings{
 		Name: "session-service:upload",
@@ -80,6 +119,11 @@ func BuildServices(app *App) *Services {
 		},
 	})
 	uploadsService := services.NewUploadsService(upStore, clientStub, uploadsBreaker)
+	uploadsService.Activity = activityStore
+	uploadsService.ConcurrencyLimit = services.RoleLimits{
+		Default: app.Settings.UploadConcurrencyLimit,
+		ByRole:  app.Settings.UploadConcurrencyLimits,
+	}
 
 	fileBreaker := gobreaker.NewCircuitBreaker[*pb.FilesReply](gobreaker.Settings{
 		Name: "session-service:get-files",
@@ -97,27 +141,102 @@ func BuildServices(app *App) *Services {
 		},
 	})
 	fileService := services.NewFileServiceImpl(clientStub, fileBreaker)
+	uploadsService.Quota = services.NewQuotaService(quotaStore, upStore, fileService, services.RoleLimits{
+		Default: app.Settings.StorageQuota,
+		ByRole:  app.Settings.StorageQuotas,
+	})
+	accountSvc := services.NewAccountService(usrStore, identityStore, apiKeyStore, fileService, authSvc, cacheSvc)
 
 	return &Services{
-		Auth:    authSvc,
-		Uploads: uploadsService,
-		Files:   fileService,
+		Auth:         authSvc,
+		APIKeys:      apiKeySvc,
+		Verification: verificationSvc,
+		Password:     passwordSvc,
+		MagicLinks:   magicLinkSvc,
+		Identities:   identitySvc,
+		Admin:        adminSvc,
+		Account:      accountSvc,
+		Uploads:      uploadsService,
+		Files:        fileService,
+
+		UploadReaper: services.NewUploadReaper(uploadsService, app.Settings.UploadSessionTTL, app.Settings.UploadReaperInterval),
 
 		Stores: &Stores{
-			users:    usrStore,
-			sessions: sessStore,
-			uploads:  upStore,
+			users:      usrStore,
+			sessions:   sessStore,
+			tokens:     tokenStore,
+			oneTime:    oneTimeStore,
+			uploads:    upStore,
+			apiKeys:    apiKeyStore,
+			identities: identityStore,
+			logins:     loginStore,
+			quotas:     quotaStore,
+			activity:   activityStore,
 		},
 
-		Providers: &Providers{
-			Github: githubProvider,
-			Google: googleProvider,
-		},
+		Providers: providers,
+
+		AccessTokens: authSvc.AccessTokens(),
+		Keys:         authSvc.Keys,
 
 		Conn: conn,
 	}
 }
 
+func buildProviders(app *App) oauth.Registry {
+	providers := oauth.Registry{}
+	providers.Register(oauth.NewGithubProvider(app.Config.GithubConfig))
+	providers.Register(oauth.NewGoogleProvider(app.Config.GoogleConfig))
+
+	for _, p := range app.Settings.OIDCProviders {
+		if _, exists := providers[p.Name]; exists {
+			log.Fatalf("oidc provider %q clashes with a built-in provider", p.Name)
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			log.Fatalf("oidc provider %q needs an issuer and a client id", p.Name)
+		}
+		providers.Register(oauth.NewOIDCProvider(oauth.OIDCConfig{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURI:  p.RedirectURI,
+			Scopes:       p.Scopes,
+		}))
+	}
+
+	return providers
+}
+
+func buildKeySet(cfg settings.Settings) *keys.KeySet {
+	var keySet *keys.KeySet
+	var err error
+	switch {
+	case cfg.JWTKeys != "":
+		keySet, err = keys.Load([]byte(cfg.JWTKeys))
+	case cfg.JWTKeysFile != "":
+		keySet, err = keys.LoadFile(cfg.JWTKeysFile)
+	default:
+		log.Println("JWT_KEYS is not set, tokens are signed with HS256")
+		return nil
+	}
+	if err != nil {
+		log.Fatalf("could not load jwt keys: %v", err)
+	}
+	if _, err := keySet.Signer(time.Now()); err != nil {
+		log.Fatalf("jwt keys: %v", err)
+	}
+	return keySet
+}
+
+func buildMailer(cfg settings.Settings) mail.Mailer {
+	if cfg.SMTPHost == "" {
+		log.Println("MAIL_LOG_ONLY is set, emails will not be sent")
+		return mail.LogMailer{}
+	}
+	return mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+}
+
 func (s *Services) Shutdown(ctx context.Context) error {
 	log.Println("shutting down services")
 
@@ -150,7 +269,14 @@ func (s *Stores) Shutdown(ctx context.Context) error {
 
 	shutdownIfPossible("users", s.users)
 	shutdownIfPossible("sessions", s.sessions)
+	shutdownIfPossible("tokens", s.tokens)
+	shutdownIfPossible("oneTime", s.oneTime)
 	shutdownIfPossible("uploads", s.uploads)
+	shutdownIfPossible("apiKeys", s.apiKeys)
+	shutdownIfPossible("identities", s.identities)
+	shutdownIfPossible("logins", s.logins)
+	shutdownIfPossible("quotas", s.quotas)
+	shutdownIfPossible("activity", s.activity)
 
 	log.Println("stores shutdown complete")
 	return nil
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	cerr "errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+	// timezones are validated against the embedded database, not the host's
+	_ "time/tzdata"
+	"unicode"
+
+	"github.com/Yulian302/lfusys-services-commons/caching"
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/logging"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"golang.org/x/text/language"
+)
+
+var (
+	ErrInvalidProfile  = cerr.New("invalid profile")
+	ErrAccountHasFiles = cerr.New("account still has files, which cannot be deleted yet")
+)
+
+type AccountService interface {
+	UpdateProfile(ctx context.Context, email string, req types.UpdateProfileRequest) (*types.User, error)
+	Export(ctx context.Context, email string) (*types.AccountExport, error)
+	Delete(ctx context.Context, email string, req types.DeleteAccountRequest, authTime int64, client types.ClientInfo) error
+}
+
+type AccountServiceImpl struct {
+	userStore     store.UserStore
+	identityStore store.IdentityStore
+	apiKeyStore   store.APIKeyStore
+	fileSvc       FileService
+	authSvc       JwtAuth
+	cachingSvc    caching.CachingService
+}
+
+func NewAccountService(userStore store.UserStore, identityStore store.IdentityStore, apiKeyStore store.APIKeyStore, fileSvc FileService, authSvc JwtAuth, cachingSvc caching.CachingService) *AccountServiceImpl {
+	return &AccountServiceImpl{
+		userStore:     userStore,
+		identityStore: identityStore,
+		apiKeyStore:   apiKeyStore,
+		fileSvc:       fileSvc,
+		authSvc:       authSvc,
+		cachingSvc:    cachingSvc,
+	}
+}
+
+// UpdateProfile validates and stores the given profile fields and returns the updated user
+func (s *AccountServiceImpl) UpdateProfile(ctx context.Context, email string, req types.UpdateProfileRequest) (*types.User, error) {
+	update, err := profileUpdate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userStore.Update(ctx, email, update); err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	if err := evictCachedUser(ctx, s.cachingSvc, email); err != nil {
+		// a stale profile would be served until the entry expires
+		return nil, fmt.Errorf("%w: evict cached user: %w", errors.ErrInternalServer, err)
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrUserNotFound, err)
+	}
+	return user, nil
+}
+
+func profileUpdate(req types.UpdateProfileRequest) (store.UserUpdate, error) {
+	update := store.UserUpdate{}
+
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if name == "" || strings.ContainsFunc(name, unicode.IsControl) {
+			return update, fmt.Errorf("%w: display name must not be empty or contain control characters", ErrInvalidProfile)
+		}
+		update.Name = &name
+	}
+
+	if req.AvatarURL != nil {
+		avatarURL := strings.TrimSpace(*req.AvatarURL)
+		if avatarURL != "" {
+			u, err := url.Parse(avatarURL)
+			if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+				return update, fmt.Errorf("%w: avatar url must be an https url", ErrInvalidProfile)
+			}
+		}
+		update.AvatarURL = &avatarURL
+	}
+
+	if req.Locale != nil {
+		locale := strings.TrimSpace(*req.Locale)
+		if locale != "" {
+			tag, err := language.Parse(locale)
+			if err != nil {
+				return update, fmt.Errorf("%w: unknown locale %q", ErrInvalidProfile, locale)
+			}
+			locale = tag.String()
+		}
+		update.Locale = &locale
+	}
+
+	if req.Timezone != nil {
+		timezone := strings.TrimSpace(*req.Timezone)
+		if timezone != "" {
+			// LoadLocation also accepts "Local", which means nothing to anyone else
+			if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+				return update, fmt.Errorf("%w: unknown timezone %q", ErrInvalidProfile, timezone)
+			}
+		}
+		update.Timezone = &timezone
+	}
+
+	return update, nil
+}
+
+func (s *AccountServiceImpl) Export(ctx context.Context, email string) (*types.AccountExport, error) {
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrUserNotFound, err)
+	}
+
+	identities, err := s.identityStore.ListByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: list identities: %w", errors.ErrInternalServer, err)
+	}
+
+	apiKeys, err := s.apiKeyStore.ListByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: list api keys: %w", errors.ErrInternalServer, err)
+	}
+
+	files, err := s.fileSvc.GetFiles(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return &types.AccountExport{
+		ExportedAt: time.Now().UTC(),
+		Profile:    types.NewUserProfile(user),
+		Identities: identities,
+		APIKeys:    apiKeys,
+		Files:      files.Files,
+	}, nil
+}
+
+// Delete removes the account for good. The session service cannot delete
+// files yet, so an account that still has files is kept: its files would
+// otherwise go to whoever registers the address next.
+func (s *AccountServiceImpl) Delete(ctx context.Context, email string, req types.DeleteAccountRequest, authTime int64, client types.ClientInfo) error {
+	if err := s.authSvc.Reauthenticate(ctx, email, req.Password, req.Code, authTime, client); err != nil {
+		return err
+	}
+
+	files, err := s.fileSvc.GetFiles(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	if len(files.Files) > 0 {
+		return ErrAccountHasFiles
+	}
+
+	if err := s.authSvc.LogoutAll(ctx, email); err != nil {
+		return err
+	}
+
+	identities, err := s.identityStore.ListByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: list identities: %w", errors.ErrInternalServer, err)
+	}
+	for _, identity := range identities {
+		if err := s.identityStore.Delete(ctx, email, identity.Provider, identity.ProviderID); err != nil && !cerr.Is(err, store.ErrIdentityNotFound) {
+			return fmt.Errorf("%w: delete identity: %w", errors.ErrInternalServer, err)
+		}
+	}
+
+	apiKeys, err := s.apiKeyStore.ListByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: list api keys: %w", errors.ErrInternalServer, err)
+	}
+	for _, key := range apiKeys {
+		if err := s.apiKeyStore.Delete(ctx, email, key.ID); err != nil && !cerr.Is(err, store.ErrAPIKeyNotFound) {
+			return fmt.Errorf("%w: delete api key: %w", errors.ErrInternalServer, err)
+		}
+	}
+
+	if err := s.userStore.Delete(ctx, email); err != nil {
+		return fmt.Errorf("%w: delete user: %w", errors.ErrInternalServer, err)
+	}
+
+	if err := evictCachedUser(ctx, s.cachingSvc, email); err != nil {
+		logging.FromContext(ctx).Error("could not evict cached user", slog.String("email", email), slog.Any("error", err))
+	}
+
+	logging.FromContext(ctx).Info("account deleted", slog.String("email", email))
+	return nil
+}
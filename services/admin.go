@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	cerr "errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/logging"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+)
+
+var (
+	ErrUnknownRole = cerr.New("unknown role")
+	// admins cannot disable or demote themselves, so there is always one left
+	ErrOwnAccount = cerr.New("not allowed on your own account")
+)
+
+const (
+	DefaultUserPageSize = 50
+	MaxUserPageSize     = 200
+)
+
+type AdminService interface {
+	ListUsers(ctx context.Context, filter store.UserFilter) (*types.UserList, error)
+	SetDisabled(ctx context.Context, admin string, email string, disabled bool) error
+	SetRoles(ctx context.Context, admin string, email string, roles []string) error
+	ForceLogout(ctx context.Context, admin string, email string) error
+}
+
+type AdminServiceImpl struct {
+	userStore store.UserStore
+	authSvc   JwtAuth
+}
+
+func NewAdminService(userStore store.UserStore, authSvc JwtAuth) *AdminServiceImpl {
+	return &AdminServiceImpl{
+		userStore: userStore,
+		authSvc:   authSvc,
+	}
+}
+
+func (s *AdminServiceImpl) ListUsers(ctx context.Context, filter store.UserFilter) (*types.UserList, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultUserPageSize
+	}
+	filter.Limit = min(filter.Limit, MaxUserPageSize)
+
+	users, next, err := s.userStore.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	list := &types.UserList{Users: make([]types.UserProfile, 0, len(users)), NextCursor: next}
+	for i := range users {
+		list.Users = append(list.Users, types.NewUserProfile(&users[i]))
+	}
+	return list, nil
+}
+
+// SetDisabled disables or re-enables an account. Disabling also ends all
+// sessions, so the user is locked out right away.
+func (s *AdminServiceImpl) SetDisabled(ctx context.Context, admin string, email string, disabled bool) error {
+	if disabled && strings.EqualFold(admin, email) {
+		return ErrOwnAccount
+	}
+
+	if err := s.userStore.Update(ctx, email, store.UserUpdate{Disabled: &disabled}); err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	logging.FromContext(ctx).Info("account disabled changed",
+		slog.String("admin", admin),
+		slog.String("email", email),
+		slog.Bool("disabled", disabled),
+	)
+
+	if disabled {
+		return s.authSvc.LogoutAll(ctx, email)
+	}
+	return nil
+}
+
+// SetRoles replaces the roles of a user. Tokens carry the roles, so the
+// user's sessions end and the next login gets the new ones.
+func (s *AdminServiceImpl) SetRoles(ctx context.Context, admin string, email string, roles []string) error {
+	for _, role := range roles {
+		if !slices.Contains(types.Roles, role) {
+			return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+		}
+	}
+	if strings.EqualFold(admin, email) && !slices.Contains(roles, types.RoleAdmin) {
+		return ErrOwnAccount
+	}
+
+	roles = slices.Compact(slices.Sorted(slices.Values(roles)))
+	if err := s.userStore.Update(ctx, email, store.UserUpdate{Roles: &roles}); err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	logging.FromContext(ctx).Info("account roles changed",
+		slog.String("admin", admin),
+		slog.String("email", email),
+		slog.Any("roles", roles),
+	)
+
+	return s.authSvc.LogoutAll(ctx, email)
+}
+
+// ForceLogout ends every session of the user
+func (s *AdminServiceImpl) ForceLogout(ctx context.Context, admin string, email string) error {
+	if _, err := s.userStore.GetByEmail(ctx, email); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("forced logout", slog.String("admin", admin), slog.String("email", email))
+
+	return s.authSvc.LogoutAll(ctx, email)
+}
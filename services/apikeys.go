@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	cerr "errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+)
+
+var ErrInvalidScope = cerr.New("invalid scope")
+
+// last_used_at is only written once per interval to keep hot keys cheap
+const apiKeyTouchInterval = time.Minute
+
+type APIKeyService interface {
+	Create(ctx context.Context, email string, req types.CreateAPIKeyRequest) (*types.CreatedAPIKey, error)
+	List(ctx context.Context, email string) ([]types.APIKey, error)
+	Revoke(ctx context.Context, email string, id string) error
+	Authenticate(ctx context.Context, key string) (*types.APIKey, *types.User, error)
+}
+
+type APIKeyServiceImpl struct {
+	apiKeyStore store.APIKeyStore
+	userStore   store.UserStore
+}
+
+func NewAPIKeyService(apiKeyStore store.APIKeyStore, userStore store.UserStore) *APIKeyServiceImpl {
+	return &APIKeyServiceImpl{
+		apiKeyStore: apiKeyStore,
+		userStore:   userStore,
+	}
+}
+
+func (s *APIKeyServiceImpl) Create(ctx context.Context, email string, req types.CreateAPIKeyRequest) (*types.CreatedAPIKey, error) {
+	for _, scope := range req.Scopes {
+		if !slices.Contains(types.Scopes, scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	plain := types.APIKeyPrefix + id + "_" + secret
+
+	apiKey := types.APIKey{
+		ID:        id,
+		UserEmail: email,
+		Name:      req.Name,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		KeyHash:   hashToken(plain),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := s.apiKeyStore.Create(ctx, apiKey); err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return &types.CreatedAPIKey{
+		APIKey: apiKey,
+		Key:    plain,
+	}, nil
+}
+
+func (s *APIKeyServiceImpl) List(ctx context.Context, email string) ([]types.APIKey, error) {
+	keys, err := s.apiKeyStore.ListByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	return keys, nil
+}
+
+func (s *APIKeyServiceImpl) Revoke(ctx context.Context, email string, id string) error {
+	return s.apiKeyStore.Delete(ctx, email, id)
+}
+
+// Authenticate returns the key and its owner. The owner is loaded on every
+// request, so keys act with the user's current verification status.
+func (s *APIKeyServiceImpl) Authenticate(ctx context.Context, key string) (*types.APIKey, *types.User, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(key, types.APIKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(key, types.APIKeyPrefix) {
+		return nil, nil, errors.ErrInvalidToken
+	}
+
+	apiKey, err := s.apiKeyStore.GetByID(ctx, id)
+	if err != nil {
+		if cerr.Is(err, store.ErrAPIKeyNotFound) {
+			return nil, nil, errors.ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashToken(key))) != 1 {
+		return nil, nil, errors.ErrInvalidToken
+	}
+
+	owner, err := s.userStore.GetByEmail(ctx, apiKey.UserEmail)
+	if err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return nil, nil, errors.ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+	// disabling an account ends its sessions but leaves its keys in place
+	if owner.Disabled {
+		return nil, nil, errors.ErrInvalidToken
+	}
+
+	now := time.Now().UTC()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.apiKeyStore.TouchLastUsed(ctx, apiKey.ID, now); err != nil {
+			log.Printf("could not update api key last use: %v", err)
+		}
+		apiKey.LastUsedAt = &now
+	}
+
+	return apiKey, owner, nil
+}
+
+// keys carry 256 bits of entropy, so a plain digest is enough to store them
+func hashToken(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
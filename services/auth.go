@@ -6,115 +6,257 @@ import (
 	cerr "errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/Yulian302/lfusys-services-commons/caching"
-	"github.com/Yulian302/lfusys-services-commons/crypt"
 	"github.com/Yulian302/lfusys-services-commons/errors"
 	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-commons/ratelimit"
+	"github.com/Yulian302/lfusys-services-gateway/auth/keys"
 	"github.com/Yulian302/lfusys-services-gateway/auth/oauth"
+	pwd "github.com/Yulian302/lfusys-services-gateway/auth/password"
 	"github.com/Yulian302/lfusys-services-gateway/auth/types"
 	"github.com/Yulian302/lfusys-services-gateway/store"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+var (
+	ErrEmailNotVerified = cerr.New("email not verified")
+	ErrAccountDisabled  = cerr.New("account disabled")
+	ErrReauthRequired   = cerr.New("reauthentication required")
+)
+
+// a login this recent counts as re-authentication for sensitive actions
+const ReauthWindow = 5 * time.Minute
+
 type LoginResponse struct {
 	AccessToken  string
 	RefreshToken string
 	User         *types.User
+
+	// set instead of the tokens when the login still needs a second factor
+	MFAToken string
+	// set instead of the tokens when an OAuth identity matches an existing
+	// account that has to confirm the link first
+	LinkToken string
 }
 
 type JwtAuth interface {
-	Login(ctx context.Context, email string, password string) (*LoginResponse, error)
+	Login(ctx context.Context, email string, password string, client types.ClientInfo) (*LoginResponse, error)
 	Register(ctx context.Context, req types.RegisterUser) error
 	GetCurrentUser(ctx context.Context, accessToken string) (*types.User, error)
-	RefreshToken(ctx context.Context, refreshToken string) (*jwttypes.TokenPair, error)
-	SaveState(ctx context.Context, state string) error
+	RefreshToken(ctx context.Context, refreshToken string, client types.ClientInfo) (*jwttypes.TokenPair, error)
+	Logout(ctx context.Context, accessToken, refreshToken string) error
+	LogoutAll(ctx context.Context, email string) error
+	Reauthenticate(ctx context.Context, email string, password string, code string, authTime int64, client types.ClientInfo) error
+	IsRevoked(ctx context.Context, claims *types.Claims) (bool, error)
 }
 
 type OAuth interface {
-	LoginOAuth(ctx context.Context, email string) (*LoginResponse, error)
+	LoginOAuth(ctx context.Context, email string, client types.ClientInfo) (*LoginResponse, error)
 	RegisterOAuth(ctx context.Context, userData oauth.OAuthUser) (types.User, error)
-	SaveState(ctx context.Context, state string) error
-	IsValidState(ctx context.Context, callbackState string) (bool, error)
+	BeginOAuth(ctx context.Context, login OAuthLogin) (oauth.AuthRequest, string, error)
+	FinishOAuth(ctx context.Context, provider string, state string, binding string) (*PendingOAuth, error)
 }
 
 type AuthService interface {
 	JwtAuth
 	OAuth
+	MFA
+	Sessions
 }
 
 type AuthServiceImpl struct {
 	userStore        store.UserStore
 	sessionStore     store.SessionStore
+	tokenStore       store.TokenStore
 	cachingSvc       caching.CachingService
 	JwtAccessSecret  string
 	JwtRefreshSecret string
+
+	// refuse password logins until the email address is verified
+	RequireVerifiedLogin bool
+	// limits mfa code attempts per user, optional
+	Limiter ratelimit.RateLimiter
+	// locks accounts and clients after repeated failed logins, optional
+	Lockout *LoginLockout
+	// asymmetric signing keys, optional. Without them tokens are signed
+	// with the HS256 secrets.
+	Keys *keys.KeySet
+	// keep accepting HS256 tokens without kid next to Keys, e.g. until the
+	// sessions from before the switch expired
+	AcceptLegacyTokens bool
 }
 
-func NewAuthServiceImpl(userStore store.UserStore, sessionStore store.SessionStore, cachingSvc caching.CachingService, jwtAccessSecret, jwtRefreshSecret string) *AuthServiceImpl {
+// TokenIDs holds the JTIs of a freshly signed token pair
+type TokenIDs struct {
+	Access  string
+	Refresh string
+}
+
+// TokenSession is what a token pair carries about the login it belongs to
+type TokenSession struct {
+	// the refresh token family, empty for pairs outside of one
+	ID         string
+	Generation int64
+	// unix time the user logged in at, kept across refreshes
+	AuthTime int64
+}
+
+func NewAuthServiceImpl(userStore store.UserStore, sessionStore store.SessionStore, tokenStore store.TokenStore, cachingSvc caching.CachingService, jwtAccessSecret, jwtRefreshSecret string) *AuthServiceImpl {
 	return &AuthServiceImpl{
 		userStore:        userStore,
 		sessionStore:     sessionStore,
+		tokenStore:       tokenStore,
 		cachingSvc:       cachingSvc,
 		JwtAccessSecret:  jwtAccessSecret,
 		JwtRefreshSecret: jwtRefreshSecret,
 	}
 }
 
-func (s *AuthServiceImpl) GenerateTokenPair(user *types.User, accessSecret, refreshSecret string) (*jwttypes.TokenPair, error) {
-	accessJti := uuid.New().String()
-	accessClaims := jwttypes.JWTClaims{
-		Issuer:    "lfusys",
-		Subject:   user.Email,
-		ExpiresAt: time.Now().Add(jwttypes.AccessTokenDuration).Unix(),
-		IssuedAt:  time.Now().Unix(),
-		Type:      "access",
-		JTI:       accessJti,
+// AccessTokens signs and verifies access tokens, the JWT middleware has to use the same
+func (s *AuthServiceImpl) AccessTokens() keys.Tokens {
+	return s.tokens(s.JwtAccessSecret)
+}
+
+func (s *AuthServiceImpl) refreshTokens() keys.Tokens {
+	return s.tokens(s.JwtRefreshSecret)
+}
+
+func (s *AuthServiceImpl) tokens(secret string) keys.Tokens {
+	tokens := keys.Tokens{Keys: s.Keys, Legacy: []byte(secret)}
+	if !s.Keys.Empty() && !s.AcceptLegacyTokens {
+		tokens.Legacy = nil
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
+	return tokens
+}
 
-	accessToken, err := t.SignedString([]byte(accessSecret))
+func (s *AuthServiceImpl) GenerateTokenPair(user *types.User, session TokenSession) (*jwttypes.TokenPair, *TokenIDs, error) {
+	accessJti := uuid.New().String()
+	accessClaims := types.Claims{
+		JWTClaims: jwttypes.JWTClaims{
+			Issuer:    "lfusys",
+			Subject:   user.Email,
+			ExpiresAt: time.Now().Add(jwttypes.AccessTokenDuration).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Type:      "access",
+			JTI:       accessJti,
+		},
+		Generation: session.Generation,
+		Verified:   user.Verified,
+		Roles:      user.RoleNames(),
+		SessionID:  session.ID,
+		AuthTime:   session.AuthTime,
+	}
+	accessToken, err := s.AccessTokens().Sign(accessClaims)
 	if err != nil {
 		log.Printf("could not sign JWT token: %v", err)
-		return nil, fmt.Errorf("%w: %w", errors.ErrTokenSignature, err)
+		return nil, nil, fmt.Errorf("%w: %w", errors.ErrTokenSignature, err)
 	}
 
 	refreshJti := uuid.New().String()
-	refreshClaims := jwttypes.JWTClaims{
-		Issuer:    "lfusys",
-		Subject:   user.Email,
-		ExpiresAt: time.Now().Add(jwttypes.RefreshTokenDuration).Unix(),
-		IssuedAt:  time.Now().Unix(),
-		Type:      "refresh",
-		JTI:       refreshJti,
+	refreshClaims := types.Claims{
+		JWTClaims: jwttypes.JWTClaims{
+			Issuer:    "lfusys",
+			Subject:   user.Email,
+			ExpiresAt: time.Now().Add(jwttypes.RefreshTokenDuration).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Type:      "refresh",
+			JTI:       refreshJti,
+		},
+		Generation: session.Generation,
+		AuthTime:   session.AuthTime,
 	}
 
-	ref := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refs, err := ref.SignedString([]byte(refreshSecret))
+	refs, err := s.refreshTokens().Sign(refreshClaims)
 	if err != nil {
 		log.Printf("could not sign refresh token: %v", err)
-		return nil, fmt.Errorf("%w: %w", errors.ErrTokenSignature, err)
+		return nil, nil, fmt.Errorf("%w: %w", errors.ErrTokenSignature, err)
 	}
 
 	return &jwttypes.TokenPair{
 		AccessToken:  accessToken,
 		RefreshToken: refs,
+	}, &TokenIDs{
+		Access:  accessJti,
+		Refresh: refreshJti,
 	}, nil
 }
 
-func (s *AuthServiceImpl) Login(ctx context.Context, email string, password string) (*LoginResponse, error) {
+// startSession signs a token pair and opens a new refresh token family for it
+func (s *AuthServiceImpl) startSession(ctx context.Context, user *types.User, client types.ClientInfo) (*jwttypes.TokenPair, error) {
+	generation, err := s.tokenStore.Generation(ctx, user.Email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: token generation: %w", errors.ErrInternalServer, err)
+	}
+
+	session := TokenSession{ID: uuid.NewString(), Generation: generation, AuthTime: time.Now().Unix()}
+	pair, ids, err := s.GenerateTokenPair(user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.tokenStore.CreateFamily(ctx, session.ID, ids.Refresh, jwttypes.RefreshTokenDuration); err != nil {
+		return nil, fmt.Errorf("%w: create token family: %w", errors.ErrInternalServer, err)
+	}
+	if err := s.recordSession(ctx, user.Email, session.ID, client); err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func (s *AuthServiceImpl) Login(ctx context.Context, email string, password string, client types.ClientInfo) (*LoginResponse, error) {
+	user, needsRehash, err := s.authenticate(ctx, email, password, client)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	if s.RequireVerifiedLogin && !user.Verified {
+		return nil, ErrEmailNotVerified
+	}
+
+	if needsRehash {
+		s.rehashPassword(ctx, user, password)
+	}
+
+	return s.finishLogin(ctx, user, client)
+}
+
+// LoginPasswordless logs in a user whose email address was just proven,
+// still asking for the second factor if there is one.
+func (s *AuthServiceImpl) LoginPasswordless(ctx context.Context, email string, client types.ClientInfo) (*LoginResponse, error) {
 	user, err := s.userStore.GetByEmail(ctx, email)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidCredentials, err)
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
 	}
 
-	if !crypt.VerifyPasswordWithSalt(password, user.Password, user.Salt) {
-		return nil, errors.ErrInvalidCredentials
+	return s.finishLogin(ctx, user, client)
+}
+
+// finishLogin starts a session for an authenticated user, or hands out an
+// MFA challenge first
+func (s *AuthServiceImpl) finishLogin(ctx context.Context, user *types.User, client types.ClientInfo) (*LoginResponse, error) {
+	if user.MFAEnabled {
+		mfaToken, err := s.mfaPendingToken(user)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResponse{
+			User:     user,
+			MFAToken: mfaToken,
+		}, nil
 	}
 
-	tokenPair, err := s.GenerateTokenPair(user, s.JwtAccessSecret, s.JwtRefreshSecret)
+	tokenPair, err := s.startSession(ctx, user, client)
 	if err != nil {
 		return nil, err
 	}
@@ -126,13 +268,16 @@ func (s *AuthServiceImpl) Login(ctx context.Context, email string, password stri
 	}, nil
 }
 
-func (s *AuthServiceImpl) LoginOAuth(ctx context.Context, email string) (*LoginResponse, error) {
+func (s *AuthServiceImpl) LoginOAuth(ctx context.Context, email string, client types.ClientInfo) (*LoginResponse, error) {
 	user, err := s.userStore.GetByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
 
-	tokenPair, err := s.GenerateTokenPair(user, s.JwtAccessSecret, s.JwtRefreshSecret)
+	tokenPair, err := s.startSession(ctx, user, client)
 	if err != nil {
 		return nil, fmt.Errorf("generating token pair: %w", err)
 	}
@@ -144,10 +289,94 @@ func (s *AuthServiceImpl) LoginOAuth(ctx context.Context, email string) (*LoginR
 	}, nil
 }
 
+// dummyHash is verified against for unknown users, so a login takes as
+// long whether or not the account exists
+var dummyHash = sync.OnceValue(func() string {
+	hash, err := pwd.Hash(uuid.NewString())
+	if err != nil {
+		log.Printf("could not create dummy password hash: %v", err)
+	}
+	return hash
+})
+
+// checkPassword verifies the password of email. Unknown users and
+// malformed hashes are reported as a wrong password.
+// CheckPassword confirms the password of email for an action short of a
+// login. Failures count into the login lockout like failed logins.
+func (s *AuthServiceImpl) CheckPassword(ctx context.Context, email string, password string, client types.ClientInfo) (*types.User, error) {
+	user, _, err := s.authenticate(ctx, email, password, client)
+	return user, err
+}
+
+// authenticate checks the password of email behind the login lockout. The
+// bool reports that the stored hash should be upgraded.
+func (s *AuthServiceImpl) authenticate(ctx context.Context, email string, password string, client types.ClientInfo) (*types.User, bool, error) {
+	if s.Lockout != nil {
+		if err := s.Lockout.Check(ctx, email, client.IP); err != nil {
+			return nil, false, err
+		}
+	}
+
+	user, ok, needsRehash, err := s.checkPassword(ctx, email, password)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		if s.Lockout != nil {
+			s.Lockout.Fail(ctx, email, client.IP)
+		}
+		return nil, false, errors.ErrInvalidCredentials
+	}
+	// with a second factor the failures are only forgotten once the code
+	// is right too, a known password must not buy more code guesses
+	if s.Lockout != nil && !user.MFAEnabled {
+		s.Lockout.Succeed(ctx, email, client.IP)
+	}
+	return user, needsRehash, nil
+}
+
+func (s *AuthServiceImpl) checkPassword(ctx context.Context, email string, password string) (*types.User, bool, bool, error) {
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		if !cerr.Is(err, errors.ErrUserNotFound) {
+			return nil, false, false, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+		}
+		_, _, _ = pwd.Verify(password, dummyHash(), "")
+		return nil, false, false, nil
+	}
+
+	ok, needsRehash, err := pwd.Verify(password, user.Password, user.Salt)
+	if err != nil {
+		log.Printf("could not verify password hash of %s: %v", email, err)
+		return nil, false, false, nil
+	}
+	return user, ok, needsRehash, nil
+}
+
+// rehashPassword upgrades a legacy or outdated hash. Failing to do so does not fail the login.
+func (s *AuthServiceImpl) rehashPassword(ctx context.Context, user *types.User, password string) {
+	hash, err := pwd.Hash(password)
+	if err != nil {
+		log.Printf("could not rehash password of %s: %v", user.Email, err)
+		return
+	}
+
+	if err := s.userStore.Update(ctx, user.Email, store.UserUpdate{Password: &hash}); err != nil {
+		log.Printf("could not store rehashed password of %s: %v", user.Email, err)
+		return
+	}
+
+	user.Password = hash
+	user.Salt = ""
+}
+
 func (s *AuthServiceImpl) Register(ctx context.Context, req types.RegisterUser) error {
-	user := newUserFromRegistration(req)
+	user, err := newUserFromRegistration(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
 
-	err := s.userStore.Create(ctx, user)
+	err = s.userStore.Create(ctx, user)
 	if err != nil {
 		if cerr.Is(err, errors.ErrUserAlreadyExists) {
 			return fmt.Errorf("%w: %w", errors.ErrUserAlreadyExists, err)
@@ -180,7 +409,7 @@ func (s *AuthServiceImpl) GetCurrentUser(ctx context.Context, accessToken string
 		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
 	}
 
-	userKey := fmt.Sprintf("user:%s", claims.Subject)
+	userKey := userCacheKey(claims.Subject)
 	cached, err := s.cachingSvc.Get(ctx, userKey)
 	if err == nil && cached != "" {
 		var cachedUser types.User
@@ -196,9 +425,12 @@ func (s *AuthServiceImpl) GetCurrentUser(ctx context.Context, accessToken string
 	}
 
 	b, err := json.Marshal(&types.PublicUser{
-		ID:    user.ID,
-		Email: user.Email,
-		Name:  user.Name,
+		ID:        user.ID,
+		Email:     user.Email,
+		Name:      user.Name,
+		AvatarURL: user.AvatarURL,
+		Locale:    user.Locale,
+		Timezone:  user.Timezone,
 	})
 	if err == nil {
 		if err = s.cachingSvc.Set(ctx, userKey, string(b), 30*time.Minute); err != nil {
@@ -211,73 +443,213 @@ func (s *AuthServiceImpl) GetCurrentUser(ctx context.Context, accessToken string
 	return user, nil
 }
 
-func (s *AuthServiceImpl) ValidateToken(tokenString string) (*jwttypes.JWTClaims, error) {
-	parsedToken, err := jwt.ParseWithClaims(tokenString, &jwttypes.JWTClaims{}, func(t *jwt.Token) (any, error) {
-		return []byte(s.JwtAccessSecret), nil
-	})
+func userCacheKey(email string) string {
+	return fmt.Sprintf("user:%s", email)
+}
+
+// evictCachedUser drops the user:<email> entry of GetCurrentUser. The caching
+// service cannot delete, an empty entry reads as a miss.
+func evictCachedUser(ctx context.Context, cachingSvc caching.CachingService, email string) error {
+	return cachingSvc.Set(ctx, userCacheKey(email), "", time.Second)
+}
+
+// ValidateToken parses an access token. With signing keys both token types
+// share a signer, so the type claim is what tells them apart.
+func (s *AuthServiceImpl) ValidateToken(tokenString string) (*types.Claims, error) {
+	parsedToken, err := s.AccessTokens().Parse(tokenString, &types.Claims{})
 
 	if err != nil || !parsedToken.Valid {
 		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
 	}
 
-	return parsedToken.Claims.(*jwttypes.JWTClaims), nil
+	claims := parsedToken.Claims.(*types.Claims)
+	if claims.Type != "access" {
+		return nil, errors.ErrInvalidTokenType
+	}
+	return claims, nil
 }
 
-func (s *AuthServiceImpl) ValidateRefreshToken(tokenString string) (*jwttypes.JWTClaims, error) {
-	parsedToken, err := jwt.ParseWithClaims(tokenString, &jwttypes.JWTClaims{}, func(t *jwt.Token) (any, error) {
-		return []byte(s.JwtRefreshSecret), nil
-	})
+func (s *AuthServiceImpl) ValidateRefreshToken(tokenString string) (*types.Claims, error) {
+	parsedToken, err := s.refreshTokens().Parse(tokenString, &types.Claims{})
 
 	if err != nil || !parsedToken.Valid {
 		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
 	}
 
-	return parsedToken.Claims.(*jwttypes.JWTClaims), nil
+	claims := parsedToken.Claims.(*types.Claims)
+	if claims.Type != "refresh" {
+		return nil, errors.ErrInvalidTokenType
+	}
+	return claims, nil
 }
 
-func (s *AuthServiceImpl) RefreshToken(ctx context.Context, refreshToken string) (*jwttypes.TokenPair, error) {
+// RefreshToken rotates the refresh token of a session. Revoked sessions
+// have no token family anymore and are refused.
+func (s *AuthServiceImpl) RefreshToken(ctx context.Context, refreshToken string, client types.ClientInfo) (*jwttypes.TokenPair, error) {
 	claims, err := s.ValidateRefreshToken(refreshToken)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
 	}
 
-	if claims.Type != "refresh" {
-		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidTokenType, err)
+	revoked, err := s.IsRevoked(ctx, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: token revoked", errors.ErrInvalidToken)
 	}
 
 	user, err := s.userStore.GetByEmail(ctx, claims.Subject)
 	if err != nil || user == nil {
 		return nil, fmt.Errorf("%w: %w", errors.ErrUserNotFound, err)
 	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
 
-	pair, err := s.GenerateTokenPair(user, s.JwtAccessSecret, s.JwtRefreshSecret)
+	familyID, err := s.tokenStore.FamilyOf(ctx, claims.JTI)
+	if err != nil {
+		if cerr.Is(err, store.ErrTokenFamilyNotFound) {
+			return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+		}
+		return nil, fmt.Errorf("%w: token family: %w", errors.ErrInternalServer, err)
+	}
+
+	pair, ids, err := s.GenerateTokenPair(user, TokenSession{ID: familyID, Generation: claims.Generation, AuthTime: claims.AuthTime})
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
 	}
+
+	familyID, err = s.tokenStore.RotateFamily(ctx, claims.JTI, ids.Refresh, jwttypes.RefreshTokenDuration)
+	if err != nil {
+		if cerr.Is(err, store.ErrTokenReused) {
+			log.Printf("refresh token reuse detected for %s, revoked token family %s", claims.Subject, familyID)
+			if err := s.tokenStore.DeleteSession(ctx, claims.Subject, familyID); err != nil {
+				log.Printf("could not delete session %s: %v", familyID, err)
+			}
+			return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+		}
+		if cerr.Is(err, store.ErrTokenFamilyNotFound) {
+			return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+		}
+		return nil, fmt.Errorf("%w: rotate token family: %w", errors.ErrInternalServer, err)
+	}
+
+	if err := s.recordSession(ctx, user.Email, familyID, client); err != nil {
+		return nil, err
+	}
+
 	return pair, nil
 }
 
-func (s *AuthServiceImpl) SaveState(ctx context.Context, state string) error {
-	if err := s.sessionStore.Create(ctx, state); err != nil {
-		log.Printf("WARN: state store unavailable, continuing without persistence: %v", err)
+// Logout revokes the given tokens. Tokens that no longer parse are already
+// unusable and are skipped.
+func (s *AuthServiceImpl) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	if accessToken != "" {
+		if claims, err := s.ValidateToken(accessToken); err == nil {
+			if err := s.tokenStore.DenyToken(ctx, claims.JTI, time.Until(time.Unix(claims.ExpiresAt, 0))); err != nil {
+				return fmt.Errorf("deny access token: %w", err)
+			}
+		}
+	}
+
+	if refreshToken != "" {
+		if claims, err := s.ValidateRefreshToken(refreshToken); err == nil {
+			if err := s.tokenStore.DenyToken(ctx, claims.JTI, time.Until(time.Unix(claims.ExpiresAt, 0))); err != nil {
+				return fmt.Errorf("deny refresh token: %w", err)
+			}
+
+			familyID, err := s.tokenStore.FamilyOf(ctx, claims.JTI)
+			if err == nil {
+				err = s.tokenStore.RevokeFamily(ctx, familyID)
+			}
+			if err == nil {
+				err = s.tokenStore.DeleteSession(ctx, claims.Subject, familyID)
+			}
+			if err != nil && !cerr.Is(err, store.ErrTokenFamilyNotFound) {
+				return fmt.Errorf("revoke token family: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// LogoutAll invalidates every token issued to the user so far
+func (s *AuthServiceImpl) LogoutAll(ctx context.Context, email string) error {
+	if _, err := s.tokenStore.BumpGeneration(ctx, email); err != nil {
+		return fmt.Errorf("%w: bump token generation: %w", errors.ErrInternalServer, err)
+	}
+
+	// the families are dead with the old generation, only their listing is left
+	sessions, err := s.tokenStore.ListSessions(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: list sessions: %w", errors.ErrInternalServer, err)
+	}
+	for _, session := range sessions {
+		if err := s.tokenStore.DeleteSession(ctx, email, session.ID); err != nil {
+			log.Printf("could not delete session %s: %v", session.ID, err)
+		}
+	}
+	return nil
+}
+
+// Reauthenticate confirms a sensitive action with the password, plus the
+// mfa code if enabled. Without a password the session has to come from a
+// login within ReauthWindow.
+func (s *AuthServiceImpl) Reauthenticate(ctx context.Context, email string, password string, code string, authTime int64, client types.ClientInfo) error {
+	if password == "" {
+		if time.Since(time.Unix(authTime, 0)) > ReauthWindow {
+			return ErrReauthRequired
+		}
+		return nil
+	}
+
+	user, err := s.CheckPassword(ctx, email, password, client)
+	if err != nil {
+		return err
+	}
+
+	if user.MFAEnabled {
+		if code == "" {
+			return ErrInvalidMFACode
+		}
+		return s.checkMFACode(ctx, user, code, client)
 	}
 	return nil
 }
 
-func (s *AuthServiceImpl) IsValidState(ctx context.Context, callbackState string) (bool, error) {
-	isStateExists, err := s.sessionStore.IsStateExists(ctx, callbackState)
+func (s *AuthServiceImpl) IsRevoked(ctx context.Context, claims *types.Claims) (bool, error) {
+	denied, err := s.tokenStore.IsTokenDenied(ctx, claims.JTI)
 	if err != nil {
-		log.Printf("state store unavailable, failing open: %v", err)
+		return false, fmt.Errorf("check token denylist: %w", err)
+	}
+	if denied {
 		return true, nil
 	}
-	if !isStateExists {
-		return false, nil
+
+	if claims.SessionID != "" {
+		denied, err := s.tokenStore.IsSessionDenied(ctx, claims.SessionID)
+		if err != nil {
+			return false, fmt.Errorf("check session denylist: %w", err)
+		}
+		if denied {
+			return true, nil
+		}
+	}
+
+	generation, err := s.tokenStore.Generation(ctx, claims.Subject)
+	if err != nil {
+		return false, fmt.Errorf("check token generation: %w", err)
 	}
-	return true, nil
+	return claims.Generation < generation, nil
 }
 
-func newUserFromRegistration(req types.RegisterUser) types.User {
-	hashedPassword, salt := crypt.HashSHA256WithSalt(req.Password)
+func newUserFromRegistration(req types.RegisterUser) (types.User, error) {
+	hashedPassword, err := pwd.Hash(req.Password)
+	if err != nil {
+		return types.User{}, fmt.Errorf("hash password: %w", err)
+	}
 	return types.User{
 		ID: uuid.NewString(),
 		RegisterUser: types.RegisterUser{
@@ -285,19 +657,23 @@ func newUserFromRegistration(req types.RegisterUser) types.User {
 			Email:    req.Email,
 			Password: hashedPassword,
 		},
-		Salt: salt,
-	}
+	}, nil
 }
 
 func newUserFromOAuth(ouser oauth.OAuthUser) types.User {
+	name := ouser.Name
+	if name == "" {
+		name = ouser.Username
+	}
 	return types.User{
 		ID: uuid.NewString(),
 		RegisterUser: types.RegisterUser{
-			Name:  ouser.Name,
+			Name:  name,
 			Email: ouser.Email,
 		},
 		OAuthProvider: ouser.Provider,
 		OAuthID:       ouser.ProviderID,
-		Verified:      true,
+		Verified:      ouser.EmailVerified,
+		AvatarURL:     ouser.AvatarURL,
 	}
 }
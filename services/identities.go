@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	cerr "errors"
+	"fmt"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-gateway/auth/oauth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrIdentityLinkedElsewhere = cerr.New("identity is linked to another account")
+	ErrLastLoginMethod         = cerr.New("cannot remove the last way to log in")
+)
+
+const (
+	oauthLinkTokenType     = "oauth_link"
+	oauthLinkTokenDuration = 10 * time.Minute
+)
+
+type IdentityService interface {
+	SignIn(ctx context.Context, ouser oauth.OAuthUser, client types.ClientInfo) (*LoginResponse, error)
+	Link(ctx context.Context, email string, ouser oauth.OAuthUser) error
+	ConfirmLink(ctx context.Context, email string, linkToken string) error
+	List(ctx context.Context, email string) ([]types.Identity, error)
+	Unlink(ctx context.Context, email string, provider string, providerID string) error
+}
+
+type IdentityServiceImpl struct {
+	identityStore store.IdentityStore
+	userStore     store.UserStore
+	tokenStore    store.TokenStore
+	authSvc       OAuth
+	secret        []byte
+}
+
+// linkClaims carry a provider identity that waits for the account owner to confirm the link
+type linkClaims struct {
+	types.Claims
+	Provider      string `json:"provider"`
+	ProviderID    string `json:"provider_id"`
+	ProviderEmail string `json:"provider_email"`
+}
+
+func NewIdentityService(identityStore store.IdentityStore, userStore store.UserStore, tokenStore store.TokenStore, authSvc OAuth, secret string) *IdentityServiceImpl {
+	return &IdentityServiceImpl{
+		identityStore: identityStore,
+		userStore:     userStore,
+		tokenStore:    tokenStore,
+		authSvc:       authSvc,
+		secret:        deriveKey(secret, oauthLinkTokenType),
+	}
+}
+
+// SignIn logs in the user an identity belongs to. Unknown identities get
+// a new account, or a LinkToken if an account with the same email exists
+// and merging them needs the owner's confirmation.
+func (s *IdentityServiceImpl) SignIn(ctx context.Context, ouser oauth.OAuthUser, client types.ClientInfo) (*LoginResponse, error) {
+	identity, err := s.identityStore.Get(ctx, ouser.Provider, ouser.ProviderID)
+	if err == nil {
+		return s.authSvc.LoginOAuth(ctx, identity.UserEmail, client)
+	}
+	if !cerr.Is(err, store.ErrIdentityNotFound) {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, ouser.Email)
+	if err != nil {
+		if !cerr.Is(err, errors.ErrUserNotFound) {
+			return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+		}
+
+		newUser, err := s.authSvc.RegisterOAuth(ctx, ouser)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Link(ctx, newUser.Email, ouser); err != nil {
+			return nil, err
+		}
+		return s.authSvc.LoginOAuth(ctx, newUser.Email, client)
+	}
+
+	// accounts created by this provider before identities were tracked
+	legacy := user.OAuthProvider == ouser.Provider && user.OAuthID == ouser.ProviderID
+	// accounts without a password can only be entered through a verified email anyway
+	passwordless := user.Password == "" && user.Verified && ouser.EmailVerified
+
+	if !legacy && !passwordless {
+		linkToken, err := s.linkToken(user.Email, ouser)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResponse{
+			LinkToken: linkToken,
+		}, nil
+	}
+
+	if err := s.Link(ctx, user.Email, ouser); err != nil {
+		return nil, err
+	}
+	return s.authSvc.LoginOAuth(ctx, user.Email, client)
+}
+
+func (s *IdentityServiceImpl) Link(ctx context.Context, email string, ouser oauth.OAuthUser) error {
+	err := s.identityStore.Create(ctx, types.Identity{
+		Provider:   ouser.Provider,
+		ProviderID: ouser.ProviderID,
+		UserEmail:  email,
+		Email:      ouser.Email,
+		LinkedAt:   time.Now().UTC(),
+	})
+	if err == nil {
+		return nil
+	}
+	if !cerr.Is(err, store.ErrIdentityExists) {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	existing, err := s.identityStore.Get(ctx, ouser.Provider, ouser.ProviderID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	if existing.UserEmail != email {
+		return ErrIdentityLinkedElsewhere
+	}
+	return nil
+}
+
+// ConfirmLink links the identity of a link token after the owner of the
+// account logged in with their existing credentials
+func (s *IdentityServiceImpl) ConfirmLink(ctx context.Context, email string, linkToken string) error {
+	parsedToken, err := jwt.ParseWithClaims(linkToken, &linkClaims{}, func(t *jwt.Token) (any, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !parsedToken.Valid {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+	}
+
+	claims := parsedToken.Claims.(*linkClaims)
+	if claims.Type != oauthLinkTokenType {
+		return errors.ErrInvalidTokenType
+	}
+	if claims.Subject != email {
+		return fmt.Errorf("%w: link token belongs to another account", errors.ErrInvalidToken)
+	}
+
+	denied, err := s.tokenStore.IsTokenDenied(ctx, claims.JTI)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	if denied {
+		return fmt.Errorf("%w: link token already used", errors.ErrInvalidToken)
+	}
+
+	err = s.Link(ctx, email, oauth.OAuthUser{
+		Email:      claims.ProviderEmail,
+		Provider:   claims.Provider,
+		ProviderID: claims.ProviderID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.tokenStore.DenyToken(ctx, claims.JTI, time.Until(time.Unix(claims.ExpiresAt, 0))); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	return nil
+}
+
+func (s *IdentityServiceImpl) List(ctx context.Context, email string) ([]types.Identity, error) {
+	identities, err := s.identityStore.ListByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	return identities, nil
+}
+
+// Unlink refuses to remove the only identity of an account without a password
+func (s *IdentityServiceImpl) Unlink(ctx context.Context, email string, provider string, providerID string) error {
+	identities, err := s.identityStore.ListByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	found := false
+	for _, identity := range identities {
+		if identity.Provider == provider && identity.ProviderID == providerID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return store.ErrIdentityNotFound
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrUserNotFound, err)
+	}
+	if user.Password == "" && len(identities) == 1 {
+		return ErrLastLoginMethod
+	}
+
+	if err := s.identityStore.Delete(ctx, email, provider, providerID); err != nil {
+		if cerr.Is(err, store.ErrIdentityNotFound) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	// otherwise the legacy fields would link the identity again on its next login
+	if user.OAuthProvider == provider && user.OAuthID == providerID {
+		empty := ""
+		if err := s.userStore.Update(ctx, email, store.UserUpdate{OAuthProvider: &empty, OAuthID: &empty}); err != nil {
+			return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *IdentityServiceImpl) linkToken(email string, ouser oauth.OAuthUser) (string, error) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, linkClaims{
+		Claims: types.Claims{
+			JWTClaims: jwttypes.JWTClaims{
+				Issuer:    "lfusys",
+				Subject:   email,
+				ExpiresAt: time.Now().Add(oauthLinkTokenDuration).Unix(),
+				IssuedAt:  time.Now().Unix(),
+				Type:      oauthLinkTokenType,
+				JTI:       uuid.NewString(),
+			},
+		},
+		Provider:      ouser.Provider,
+		ProviderID:    ouser.ProviderID,
+		ProviderEmail: ouser.Email,
+	}).SignedString(s.secret)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errors.ErrTokenSignature, err)
+	}
+	return token, nil
+}
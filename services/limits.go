@@ -0,0 +1,30 @@
+package services
+
+// RoleLimits is a limit that can be raised or lowered per role, e.g. for
+// paying users. A limit of 0 or less means unlimited.
+type RoleLimits struct {
+	Default int64
+	ByRole  map[string]int64
+}
+
+// For returns the most generous limit among roles, or the default if none
+// of them has its own
+func (l RoleLimits) For(roles []string) int64 {
+	limit, found := int64(0), false
+	for _, role := range roles {
+		roleLimit, ok := l.ByRole[role]
+		if !ok {
+			continue
+		}
+		if roleLimit <= 0 {
+			return 0
+		}
+		if !found || roleLimit > limit {
+			limit, found = roleLimit, true
+		}
+	}
+	if !found {
+		return l.Default
+	}
+	return limit
+}
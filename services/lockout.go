@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	cerr "errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-gateway/logging"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+)
+
+var ErrLoginLocked = cerr.New("too many failed login attempts")
+
+// LoginLockout locks accounts and client IPs for a while after repeated
+// failed password logins. Every failure past the threshold doubles the
+// lockout. Store errors never block a login.
+type LoginLockout struct {
+	attempts store.LoginAttemptStore
+
+	// failures of one account from one IP. Counted per IP, so nobody can
+	// lock a user out of their account just by knowing the address.
+	AccountIPThreshold int64
+	// failures of one account from all IPs together, catches guessing
+	// spread over many IPs
+	AccountThreshold int64
+	// higher than the account and IP threshold, many users can share an IP
+	IPThreshold int64
+	// how long failures are remembered
+	Window      time.Duration
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+func NewLoginLockout(attempts store.LoginAttemptStore) *LoginLockout {
+	return &LoginLockout{
+		attempts:           attempts,
+		AccountIPThreshold: 5,
+		AccountThreshold:   100,
+		IPThreshold:        50,
+		Window:             time.Hour,
+		BaseLockout:        time.Minute,
+		MaxLockout:         time.Hour,
+	}
+}
+
+type lockoutKey struct {
+	kind      string
+	value     string
+	threshold int64
+}
+
+func (l *LoginLockout) keys(email, ip string) []lockoutKey {
+	email = strings.ToLower(email)
+	keys := []lockoutKey{{"account", email, l.AccountThreshold}}
+	if ip != "" {
+		keys = append(keys,
+			lockoutKey{"account_ip", email + "|" + ip, l.AccountIPThreshold},
+			lockoutKey{"ip", ip, l.IPThreshold},
+		)
+	}
+	return keys
+}
+
+func (k lockoutKey) String() string {
+	return k.kind + ":" + k.value
+}
+
+// Check returns ErrLoginLocked while the account or the client IP is locked
+func (l *LoginLockout) Check(ctx context.Context, email, ip string) error {
+	for _, key := range l.keys(email, ip) {
+		lockedFor, err := l.attempts.LockedFor(ctx, key.String())
+		if err != nil {
+			logging.FromContext(ctx).Error("could not check login lockout", slog.String(key.kind, key.value), slog.Any("error", err))
+			continue
+		}
+		if lockedFor > 0 {
+			logging.FromContext(ctx).Warn("login rejected, locked out",
+				slog.String(key.kind, key.value),
+				slog.Duration("locked_for", lockedFor),
+			)
+			return ErrLoginLocked
+		}
+	}
+	return nil
+}
+
+// Fail records a failed login and locks whatever crossed its threshold
+func (l *LoginLockout) Fail(ctx context.Context, email, ip string) {
+	for _, key := range l.keys(email, ip) {
+		failures, err := l.attempts.Fail(ctx, key.String(), l.Window)
+		if err != nil {
+			logging.FromContext(ctx).Error("could not record failed login", slog.String(key.kind, key.value), slog.Any("error", err))
+			continue
+		}
+		if failures < key.threshold {
+			continue
+		}
+
+		d := l.lockout(failures - key.threshold)
+		if err := l.attempts.Lock(ctx, key.String(), d); err != nil {
+			logging.FromContext(ctx).Error("could not lock out login", slog.String(key.kind, key.value), slog.Any("error", err))
+			continue
+		}
+		logging.FromContext(ctx).Warn("login locked out",
+			slog.String(key.kind, key.value),
+			slog.Int64("failures", failures),
+			slog.Duration("locked_for", d),
+		)
+	}
+}
+
+// Succeed forgets the failures of the account. Those of the IP stay, an
+// attacker could otherwise reset them with a login of their own.
+func (l *LoginLockout) Succeed(ctx context.Context, email, ip string) {
+	for _, key := range l.keys(email, ip) {
+		if key.kind == "ip" {
+			continue
+		}
+		if err := l.attempts.Reset(ctx, key.String()); err != nil {
+			logging.FromContext(ctx).Error("could not reset failed logins", slog.String(key.kind, key.value), slog.Any("error", err))
+		}
+	}
+}
+
+func (l *LoginLockout) lockout(over int64) time.Duration {
+	d := l.BaseLockout
+	for range over {
+		d *= 2
+		if d >= l.MaxLockout {
+			return l.MaxLockout
+		}
+	}
+	return d
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	cerr "errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/ratelimit"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/mail"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/google/uuid"
+)
+
+const (
+	magicLinkPurpose = "magic_link"
+	magicLinkTTL     = 15 * time.Minute
+
+	magicLinkLimit  = 5
+	magicLinkWindow = time.Hour
+)
+
+type MagicLinkService interface {
+	Send(ctx context.Context, email string) error
+	Verify(ctx context.Context, token string, client types.ClientInfo) (*LoginResponse, error)
+}
+
+// PasswordlessLogin logs in users that proved their email address some other way
+type PasswordlessLogin interface {
+	SessionTerminator
+	LoginPasswordless(ctx context.Context, email string, client types.ClientInfo) (*LoginResponse, error)
+}
+
+type MagicLinkServiceImpl struct {
+	userStore   store.UserStore
+	linkTokens  store.OneTimeTokenStore
+	authSvc     PasswordlessLogin
+	takeover    accountTakeover
+	mailer      mail.Mailer
+	limiter     ratelimit.RateLimiter
+	frontendURL string
+}
+
+func NewMagicLinkService(userStore store.UserStore, apiKeyStore store.APIKeyStore, identityStore store.IdentityStore, linkTokens store.OneTimeTokenStore, authSvc PasswordlessLogin, mailer mail.Mailer, limiter ratelimit.RateLimiter, frontendURL string) *MagicLinkServiceImpl {
+	return &MagicLinkServiceImpl{
+		userStore:  userStore,
+		linkTokens: linkTokens,
+		authSvc:    authSvc,
+		takeover: accountTakeover{
+			userStore:     userStore,
+			apiKeyStore:   apiKeyStore,
+			identityStore: identityStore,
+			sessions:      authSvc,
+		},
+		mailer:      mailer,
+		limiter:     limiter,
+		frontendURL: frontendURL,
+	}
+}
+
+// Send mails a single-use sign in link. Unknown addresses get one too,
+// the account is created once the link is used.
+func (s *MagicLinkServiceImpl) Send(ctx context.Context, email string) error {
+	if s.limiter != nil {
+		key := fmt.Sprintf("rate:magic:%s", strings.ToLower(email))
+		count, err := s.limiter.Incr(ctx, key)
+		if err == nil {
+			if count == 1 {
+				_ = s.limiter.Expire(ctx, key, magicLinkWindow)
+			}
+			if count > magicLinkLimit {
+				return ErrTooManyRequests
+			}
+		}
+	}
+
+	token, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	if err := s.linkTokens.Save(ctx, magicLinkPurpose, hashToken(token), email, magicLinkTTL); err != nil {
+		return fmt.Errorf("%w: save magic link token: %w", errors.ErrInternalServer, err)
+	}
+
+	link := s.frontendURL + "/magic-link?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Your sign in link",
+		Body: fmt.Sprintf(
+			"Hi,\n\nopen the link below to sign in:\n\n%s\n\nThe link expires in 15 minutes and can only be used once. If you did not ask for it, you can ignore this email.",
+			link,
+		),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: send magic link mail: %w", errors.ErrInternalServer, err)
+	}
+
+	return nil
+}
+
+// Verify consumes a magic link token and logs in the user it was sent to,
+// creating the account on first use.
+func (s *MagicLinkServiceImpl) Verify(ctx context.Context, token string, client types.ClientInfo) (*LoginResponse, error) {
+	email, err := s.linkTokens.Consume(ctx, magicLinkPurpose, hashToken(token))
+	if err != nil {
+		if cerr.Is(err, store.ErrOneTimeTokenNotFound) {
+			return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+		}
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, email)
+	switch {
+	case err == nil:
+		// the link proves ownership of the address
+		if !user.Verified {
+			if err := s.takeover.takeOver(ctx, email, ""); err != nil {
+				return nil, err
+			}
+		}
+	case cerr.Is(err, errors.ErrUserNotFound):
+		if err := s.userStore.Create(ctx, newUserFromEmail(email)); err != nil && !cerr.Is(err, errors.ErrUserAlreadyExists) {
+			return nil, fmt.Errorf("%w: create user: %w", errors.ErrInternalServer, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return s.authSvc.LoginPasswordless(ctx, email, client)
+}
+
+// newUserFromEmail is a passwordless account, named after the address until the user says otherwise
+func newUserFromEmail(email string) types.User {
+	name, _, _ := strings.Cut(email, "@")
+	return types.User{
+		ID: uuid.NewString(),
+		RegisterUser: types.RegisterUser{
+			Name:  name,
+			Email: email,
+		},
+		Verified: true,
+	}
+}
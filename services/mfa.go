@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	cerr "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-gateway/auth/totp"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidMFACode     = cerr.New("invalid mfa code")
+	ErrMFAAlreadyEnabled  = cerr.New("mfa already enabled")
+	ErrMFANotEnabled      = cerr.New("mfa not enabled")
+	ErrMFANotInitiated    = cerr.New("mfa setup not started")
+	ErrTooManyMFAAttempts = cerr.New("too many mfa attempts")
+)
+
+const (
+	mfaIssuer = "lfusys"
+
+	mfaPendingTokenType     = "mfa_pending"
+	mfaPendingTokenDuration = 5 * time.Minute
+
+	// code attempts per user, however many logins they are spread over
+	mfaMaxAttempts   = 5
+	mfaAttemptWindow = 15 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+type MFA interface {
+	SetupMFA(ctx context.Context, email string) (*types.MFASetupResponse, error)
+	ConfirmMFA(ctx context.Context, email string, code string) ([]string, error)
+	DisableMFA(ctx context.Context, email string, code string, client types.ClientInfo) error
+	VerifyMFA(ctx context.Context, mfaToken string, code string, client types.ClientInfo) (*LoginResponse, error)
+}
+
+// SetupMFA stores a new secret. MFA is only enforced once the secret is
+// confirmed with a valid code.
+func (s *AuthServiceImpl) SetupMFA(ctx context.Context, email string) (*types.MFASetupResponse, error) {
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrUserNotFound, err)
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	if err := s.userStore.Update(ctx, email, store.UserUpdate{MFASecret: &secret}); err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return &types.MFASetupResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(mfaIssuer, email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables MFA and returns the recovery codes. They are only
+// stored hashed and cannot be shown again.
+func (s *AuthServiceImpl) ConfirmMFA(ctx context.Context, email string, code string) ([]string, error) {
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrUserNotFound, err)
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFASecret == "" {
+		return nil, ErrMFANotInitiated
+	}
+
+	step, ok := totp.Validate(user.MFASecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	if err := s.userStore.ConsumeMFAStep(ctx, email, step); err != nil {
+		if cerr.Is(err, store.ErrMFACodeUsed) {
+			return nil, ErrInvalidMFACode
+		}
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := randomString(5, hex.EncodeToString)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	enabled := true
+	if err := s.userStore.Update(ctx, email, store.UserUpdate{MFAEnabled: &enabled, MFARecoveryCodes: &hashes}); err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return codes, nil
+}
+
+// DisableMFA requires a valid code, a stolen session alone is not enough
+func (s *AuthServiceImpl) DisableMFA(ctx context.Context, email string, code string, client types.ClientInfo) error {
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrUserNotFound, err)
+	}
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+
+	if err := s.checkMFACode(ctx, user, code, client); err != nil {
+		return err
+	}
+
+	disabled := false
+	secret := ""
+	codes := []string{}
+	if err := s.userStore.Update(ctx, email, store.UserUpdate{MFAEnabled: &disabled, MFASecret: &secret, MFARecoveryCodes: &codes}); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return nil
+}
+
+// VerifyMFA completes a password login that was answered with an mfa_pending token
+func (s *AuthServiceImpl) VerifyMFA(ctx context.Context, mfaToken string, code string, client types.ClientInfo) (*LoginResponse, error) {
+	parsedToken, err := jwt.ParseWithClaims(mfaToken, &types.Claims{}, func(t *jwt.Token) (any, error) {
+		return deriveKey(s.JwtAccessSecret, mfaPendingTokenType), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !parsedToken.Valid {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+	}
+
+	claims := parsedToken.Claims.(*types.Claims)
+	if claims.Type != mfaPendingTokenType {
+		return nil, errors.ErrInvalidTokenType
+	}
+
+	denied, err := s.tokenStore.IsTokenDenied(ctx, claims.JTI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	if denied {
+		return nil, fmt.Errorf("%w: mfa token already used", errors.ErrInvalidToken)
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrUserNotFound, err)
+	}
+	if !user.MFAEnabled {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInvalidToken, ErrMFANotEnabled)
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	if err := s.checkMFACode(ctx, user, code, client); err != nil {
+		return nil, err
+	}
+
+	if err := s.tokenStore.DenyToken(ctx, claims.JTI, time.Until(time.Unix(claims.ExpiresAt, 0))); err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	tokenPair, err := s.startSession(ctx, user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		AccessToken:  tokenPair.AccessToken,
+		RefreshToken: tokenPair.RefreshToken,
+		User:         user,
+	}, nil
+}
+
+func (s *AuthServiceImpl) mfaPendingToken(user *types.User) (string, error) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, types.Claims{
+		JWTClaims: jwttypes.JWTClaims{
+			Issuer:    "lfusys",
+			Subject:   user.Email,
+			ExpiresAt: time.Now().Add(mfaPendingTokenDuration).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Type:      mfaPendingTokenType,
+			JTI:       uuid.NewString(),
+		},
+	}).SignedString(deriveKey(s.JwtAccessSecret, mfaPendingTokenType))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errors.ErrTokenSignature, err)
+	}
+	return token, nil
+}
+
+// checkMFACode accepts either a current TOTP code or an unused recovery
+// code. Every caller shares the attempt limit of the user, and wrong codes
+// count into the login lockout like wrong passwords.
+func (s *AuthServiceImpl) checkMFACode(ctx context.Context, user *types.User, code string, client types.ClientInfo) error {
+	if s.Lockout != nil {
+		if err := s.Lockout.Check(ctx, user.Email, client.IP); err != nil {
+			return err
+		}
+	}
+
+	if s.Limiter != nil {
+		key := fmt.Sprintf("rate:mfa:%s", strings.ToLower(user.Email))
+		count, err := s.Limiter.Incr(ctx, key)
+		if err == nil {
+			if count == 1 {
+				_ = s.Limiter.Expire(ctx, key, mfaAttemptWindow)
+			}
+			if count > mfaMaxAttempts {
+				return ErrTooManyMFAAttempts
+			}
+		}
+	}
+
+	err := s.validateMFACode(ctx, user, code)
+	if s.Lockout != nil {
+		if err == nil {
+			s.Lockout.Succeed(ctx, user.Email, client.IP)
+		} else if cerr.Is(err, ErrInvalidMFACode) {
+			s.Lockout.Fail(ctx, user.Email, client.IP)
+		}
+	}
+	return err
+}
+
+func (s *AuthServiceImpl) validateMFACode(ctx context.Context, user *types.User, code string) error {
+	code = strings.TrimSpace(code)
+
+	if step, ok := totp.Validate(user.MFASecret, code, time.Now()); ok {
+		err := s.userStore.ConsumeMFAStep(ctx, user.Email, step)
+		if cerr.Is(err, store.ErrMFACodeUsed) {
+			return ErrInvalidMFACode
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+		}
+		return nil
+	}
+
+	if len(code) == totp.Digits {
+		return ErrInvalidMFACode
+	}
+
+	err := s.userStore.ConsumeRecoveryCode(ctx, user.Email, hashToken(normalizeRecoveryCode(code)))
+	if cerr.Is(err, store.ErrMFACodeUsed) {
+		return ErrInvalidMFACode
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	return nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	cerr "errors"
+	"fmt"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-gateway/auth/oauth"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+)
+
+var ErrInvalidState = cerr.New("invalid oauth state")
+
+const (
+	OAuthStateTTL = 10 * time.Minute
+
+	oauthStateBindingPurpose = "oauth_state_binding"
+)
+
+// OAuthLogin describes a login with a provider as the browser started it
+type OAuthLogin struct {
+	Provider string `json:"provider"`
+	// set when the identity is linked to this user instead of signing in
+	LinkEmail string `json:"link_email,omitempty"`
+	// where the user wants to go afterwards, checked again at the callback
+	ReturnTo string `json:"return_to,omitempty"`
+}
+
+// PendingOAuth is a login that was sent to a provider and waits for its callback
+type PendingOAuth struct {
+	oauth.AuthRequest
+	OAuthLogin
+}
+
+// BeginOAuth creates the state, nonce and PKCE verifier of a login and
+// keeps them until the callback. The returned binding has to
+// be handed to the browser that starts the login and come back with its
+// callback, so the state cannot be used from another browser.
+func (s *AuthServiceImpl) BeginOAuth(ctx context.Context, login OAuthLogin) (oauth.AuthRequest, string, error) {
+	req, err := oauth.NewAuthRequest()
+	if err != nil {
+		return oauth.AuthRequest{}, "", fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	value, err := json.Marshal(PendingOAuth{
+		AuthRequest: req,
+		OAuthLogin:  login,
+	})
+	if err != nil {
+		return oauth.AuthRequest{}, "", fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	// without the stored verifier the login cannot be completed, so a
+	// failing store fails the login instead of skipping the state check
+	if err := s.sessionStore.Create(ctx, oauth.OAuthPrefix+req.State, string(value), OAuthStateTTL); err != nil {
+		return oauth.AuthRequest{}, "", fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return req, s.stateBinding(req.State), nil
+}
+
+// FinishOAuth consumes the login started for state. States are single use
+// and only valid for the provider and the browser they were created for.
+func (s *AuthServiceImpl) FinishOAuth(ctx context.Context, provider string, state string, binding string) (*PendingOAuth, error) {
+	if !hmac.Equal([]byte(binding), []byte(s.stateBinding(state))) {
+		return nil, ErrInvalidState
+	}
+
+	value, err := s.sessionStore.Take(ctx, oauth.OAuthPrefix+state)
+	if err != nil {
+		if cerr.Is(err, store.ErrStateNotFound) {
+			return nil, ErrInvalidState
+		}
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	var pending PendingOAuth
+	if err := json.Unmarshal([]byte(value), &pending); err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	if pending.Provider != provider {
+		return nil, ErrInvalidState
+	}
+
+	return &pending, nil
+}
+
+// stateBinding signs state, the browser keeps it in a short lived cookie
+func (s *AuthServiceImpl) stateBinding(state string) string {
+	mac := hmac.New(sha256.New, deriveKey(s.JwtAccessSecret, oauthStateBindingPurpose))
+	mac.Write([]byte(state))
+	return state + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	cerr "errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-commons/ratelimit"
+	pwd "github.com/Yulian302/lfusys-services-gateway/auth/password"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/mail"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+)
+
+const (
+	passwordResetPurpose = "password_reset"
+	passwordResetTTL     = time.Hour
+
+	passwordResetLimit  = 3
+	passwordResetWindow = time.Hour
+)
+
+type PasswordService interface {
+	Forgot(ctx context.Context, email string) error
+	Reset(ctx context.Context, token string, newPassword string) error
+	Change(ctx context.Context, email string, currentPassword string, newPassword string, client types.ClientInfo) error
+}
+
+// PasswordCheck confirms current passwords behind the login lockout
+type PasswordCheck interface {
+	SessionTerminator
+	CheckPassword(ctx context.Context, email string, password string, client types.ClientInfo) (*types.User, error)
+}
+
+type PasswordServiceImpl struct {
+	userStore   store.UserStore
+	resetTokens store.OneTimeTokenStore
+	authSvc     PasswordCheck
+	takeover    accountTakeover
+	mailer      mail.Mailer
+	limiter     ratelimit.RateLimiter
+	frontendURL string
+}
+
+func NewPasswordService(userStore store.UserStore, apiKeyStore store.APIKeyStore, identityStore store.IdentityStore, resetTokens store.OneTimeTokenStore, authSvc PasswordCheck, mailer mail.Mailer, limiter ratelimit.RateLimiter, frontendURL string) *PasswordServiceImpl {
+	return &PasswordServiceImpl{
+		userStore:   userStore,
+		resetTokens: resetTokens,
+		authSvc:     authSvc,
+		takeover: accountTakeover{
+			userStore:     userStore,
+			apiKeyStore:   apiKeyStore,
+			identityStore: identityStore,
+			sessions:      authSvc,
+		},
+		mailer:      mailer,
+		limiter:     limiter,
+		frontendURL: frontendURL,
+	}
+}
+
+// Forgot mails a single-use reset link. Unknown addresses are silently
+// ignored so the endpoint cannot be used to probe accounts.
+func (s *PasswordServiceImpl) Forgot(ctx context.Context, email string) error {
+	if s.limiter != nil {
+		key := fmt.Sprintf("rate:reset:%s", email)
+		count, err := s.limiter.Incr(ctx, key)
+		if err == nil {
+			if count == 1 {
+				_ = s.limiter.Expire(ctx, key, passwordResetWindow)
+			}
+			if count > passwordResetLimit {
+				return ErrTooManyRequests
+			}
+		}
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return nil
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	token, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	if err := s.resetTokens.Save(ctx, passwordResetPurpose, hashToken(token), user.Email, passwordResetTTL); err != nil {
+		return fmt.Errorf("%w: save reset token: %w", errors.ErrInternalServer, err)
+	}
+
+	link := s.frontendURL + "/reset-password?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nsomeone asked to reset the password of your account. If that was you, open the link below:\n\n%s\n\nThe link expires in 1 hour and can only be used once. If you did not ask for it, you can ignore this email.",
+			user.Name,
+			link,
+		),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: send reset mail: %w", errors.ErrInternalServer, err)
+	}
+
+	return nil
+}
+
+// Reset sets a new password and ends every session of the user. The link
+// proves ownership of the address, so an unverified account is taken over
+// like on email verification.
+func (s *PasswordServiceImpl) Reset(ctx context.Context, token string, newPassword string) error {
+	email, err := s.resetTokens.Consume(ctx, passwordResetPurpose, hashToken(token))
+	if err != nil {
+		if cerr.Is(err, store.ErrOneTimeTokenNotFound) {
+			return fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	hash, err := pwd.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	if !user.Verified {
+		return s.takeover.takeOver(ctx, email, hash)
+	}
+
+	if err := s.userStore.Update(ctx, email, store.UserUpdate{Password: &hash}); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return s.authSvc.LogoutAll(ctx, email)
+}
+
+func (s *PasswordServiceImpl) Change(ctx context.Context, email string, currentPassword string, newPassword string, client types.ClientInfo) error {
+	if _, err := s.authSvc.CheckPassword(ctx, email, currentPassword, client); err != nil {
+		return err
+	}
+
+	hash, err := pwd.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	if err := s.userStore.Update(ctx, email, store.UserUpdate{Password: &hash}); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	return nil
+}
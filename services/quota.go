@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	cerr "errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-gateway/logging"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	uploadstypes "github.com/Yulian302/lfusys-services-gateway/uploads/types"
+	"github.com/google/uuid"
+)
+
+var ErrQuotaExceeded = cerr.New("storage quota exceeded")
+
+// reservations made before the session service named the upload
+const (
+	pendingReservationPrefix = "pending:"
+	pendingReservationTTL    = time.Minute
+)
+
+// QuotaExceededError says how much room a user has left. It matches ErrQuotaExceeded.
+type QuotaExceededError struct {
+	Limit     int64
+	Used      int64
+	Reserved  int64
+	Requested int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: %d bytes requested, %d of %d bytes left", ErrQuotaExceeded, e.Requested, e.Remaining(), e.Limit)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+func (e *QuotaExceededError) Remaining() int64 {
+	return max(0, e.Limit-e.Used-e.Reserved)
+}
+
+// TooLarge tells whether the upload would not fit even into an empty quota
+func (e *QuotaExceededError) TooLarge() bool {
+	return e.Requested > e.Limit
+}
+
+// UploadQuota reserves storage for uploads before they start
+type UploadQuota interface {
+	// Reserve returns a reservation ID to Confirm or Release, "" when
+	// roles have no quota and nothing was reserved
+	Reserve(ctx context.Context, email string, roles []string, bytes int64) (string, error)
+	Confirm(ctx context.Context, email string, reservationID string, uploadID string) error
+	Release(ctx context.Context, email string, id string) error
+	Usage(ctx context.Context, email string, roles []string) (*uploadstypes.StorageUsage, error)
+}
+
+// QuotaServiceImpl counts the bytes of a user's files as used and the
+// sizes of their active uploads as reserved
+type QuotaServiceImpl struct {
+	quotaStore   store.QuotaStore
+	uploadsStore store.UploadsStore
+	fileSvc      FileService
+	limits       RoleLimits
+}
+
+func NewQuotaService(quotaStore store.QuotaStore, uploadsStore store.UploadsStore, fileSvc FileService, limits RoleLimits) *QuotaServiceImpl {
+	return &QuotaServiceImpl{
+		quotaStore:   quotaStore,
+		uploadsStore: uploadsStore,
+		fileSvc:      fileSvc,
+		limits:       limits,
+	}
+}
+
+func (s *QuotaServiceImpl) Reserve(ctx context.Context, email string, roles []string, bytes int64) (string, error) {
+	limit := s.limits.For(roles)
+	if limit == 0 {
+		// without a quota the files need not be counted, so uploads do not
+		// depend on the session service listing them
+		return "", nil
+	}
+
+	used, _, err := s.usedBytes(ctx, email)
+	if err != nil {
+		return "", err
+	}
+	reserved, err := s.prune(ctx, email)
+	if err != nil {
+		return "", err
+	}
+	if bytes > limit {
+		return "", &QuotaExceededError{Limit: limit, Used: used, Reserved: reserved, Requested: bytes}
+	}
+
+	id := pendingReservationPrefix + strconv.FormatInt(time.Now().UnixMilli(), 10) + ":" + uuid.NewString()
+	ok, reserved, err := s.quotaStore.Reserve(ctx, email, id, bytes, used, limit)
+	if err != nil {
+		return "", fmt.Errorf("%w: reserve storage: %w", errors.ErrInternalServer, err)
+	}
+	if !ok {
+		return "", &QuotaExceededError{Limit: limit, Used: used, Reserved: reserved, Requested: bytes}
+	}
+	return id, nil
+}
+
+func (s *QuotaServiceImpl) Confirm(ctx context.Context, email string, reservationID string, uploadID string) error {
+	if reservationID == "" {
+		return nil
+	}
+	if err := s.quotaStore.Rename(ctx, email, reservationID, uploadID); err != nil {
+		return fmt.Errorf("%w: confirm reservation: %w", errors.ErrInternalServer, err)
+	}
+	return nil
+}
+
+func (s *QuotaServiceImpl) Release(ctx context.Context, email string, id string) error {
+	if err := s.quotaStore.Release(ctx, email, id); err != nil {
+		return fmt.Errorf("%w: release reservation: %w", errors.ErrInternalServer, err)
+	}
+	return nil
+}
+
+func (s *QuotaServiceImpl) Usage(ctx context.Context, email string, roles []string) (*uploadstypes.StorageUsage, error) {
+	used, files, err := s.usedBytes(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := s.prune(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &uploadstypes.StorageUsage{
+		UsedBytes:     used,
+		ReservedBytes: reserved,
+		LimitBytes:    s.limits.For(roles),
+		Files:         files,
+	}
+	if usage.LimitBytes > 0 {
+		remaining := max(0, usage.LimitBytes-used-reserved)
+		usage.RemainingBytes = &remaining
+	}
+	return usage, nil
+}
+
+// usedBytes sums the sizes of the finished files of email
+func (s *QuotaServiceImpl) usedBytes(ctx context.Context, email string) (int64, int, error) {
+	files, err := s.fileSvc.GetFiles(ctx, email)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: get files: %w", errors.ErrServiceUnavailable, err)
+	}
+
+	var used int64
+	for _, f := range files.Files {
+		used += int64(f.Size)
+	}
+	return used, len(files.Files), nil
+}
+
+// prune releases reservations of uploads that are no longer active, in
+// case a release got lost, and returns the bytes still reserved
+func (s *QuotaServiceImpl) prune(ctx context.Context, email string) (int64, error) {
+	reservations, err := s.quotaStore.Reservations(ctx, email)
+	if err != nil {
+		return 0, fmt.Errorf("%w: get reservations: %w", errors.ErrInternalServer, err)
+	}
+	if len(reservations) == 0 {
+		return 0, nil
+	}
+
+	uploads, err := s.uploadsStore.ListActive(ctx, email)
+	if err != nil {
+		return 0, fmt.Errorf("%w: list uploads: %w", errors.ErrInternalServer, err)
+	}
+	active := make(map[string]bool, len(uploads))
+	for _, upload := range uploads {
+		active[upload.UploadId] = true
+	}
+
+	var reserved int64
+	var stale []string
+	for id, bytes := range reservations {
+		if active[id] || (strings.HasPrefix(id, pendingReservationPrefix) && !pendingExpired(id)) {
+			reserved += bytes
+			continue
+		}
+		stale = append(stale, id)
+	}
+
+	if err := s.quotaStore.Release(ctx, email, stale...); err != nil {
+		logging.FromContext(ctx).Error("could not release stale reservations", slog.String("email", email), slog.Any("error", err))
+	}
+	return reserved, nil
+}
+
+// pendingExpired tells whether a pending reservation is too old to still
+// be waiting for its upload
+func pendingExpired(id string) bool {
+	created, _, _ := strings.Cut(strings.TrimPrefix(id, pendingReservationPrefix), ":")
+	ms, err := strconv.ParseInt(created, 10, 64)
+	if err != nil {
+		return true
+	}
+	return time.Since(time.UnixMilli(ms)) > pendingReservationTTL
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-gateway/logging"
+)
+
+// UploadExpirer expires uploads that saw no activity for longer than ttl,
+// logging through the logger of ctx
+type UploadExpirer interface {
+	ExpireStale(ctx context.Context, ttl time.Duration) (int, error)
+}
+
+// UploadReaper periodically expires abandoned upload sessions
+type UploadReaper struct {
+	expirer  UploadExpirer
+	ttl      time.Duration
+	interval time.Duration
+	logger   *slog.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewUploadReaper(expirer UploadExpirer, ttl time.Duration, interval time.Duration) *UploadReaper {
+	return &UploadReaper{
+		expirer:  expirer,
+		ttl:      ttl,
+		interval: interval,
+		logger:   slog.Default().With(slog.String("component", "upload-reaper")),
+	}
+}
+
+// Start runs the reaper in the background until Stop is called
+func (r *UploadReaper) Start() {
+	ctx, cancel := context.WithCancel(logging.WithLogger(context.Background(), r.logger))
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.Run(ctx)
+			}
+		}
+	}()
+
+	r.logger.Info("upload reaper started", slog.Duration("ttl", r.ttl), slog.Duration("interval", r.interval))
+}
+
+// Run expires stale uploads once
+func (r *UploadReaper) Run(ctx context.Context) {
+	start := time.Now()
+
+	expired, err := r.expirer.ExpireStale(ctx, r.ttl)
+	if err != nil {
+		r.logger.Error("upload reaper run failed", slog.Any("error", err), slog.Duration("duration", time.Since(start)))
+		return
+	}
+
+	r.logger.Info("upload reaper run completed", slog.Int("expired", expired), slog.Duration("duration", time.Since(start)))
+}
+
+// Stop ends the background loop and waits for a running pass, at most until ctx is done
+func (r *UploadReaper) Stop(ctx context.Context) error {
+	if r.cancel == nil {
+		return nil
+	}
+
+	r.once.Do(r.cancel)
+	select {
+	case <-r.done:
+		r.logger.Info("upload reaper stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
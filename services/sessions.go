@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	cerr "errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+)
+
+// user agents are client supplied, only this much of one is kept
+const maxUserAgentLength = 256
+
+type Sessions interface {
+	ListSessions(ctx context.Context, email string, currentID string) ([]types.Session, error)
+	RevokeSession(ctx context.Context, email string, id string) error
+}
+
+// ListSessions returns the active sessions of a user, the one with
+// currentID is marked as current.
+func (s *AuthServiceImpl) ListSessions(ctx context.Context, email string, currentID string) ([]types.Session, error) {
+	sessions, err := s.tokenStore.ListSessions(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: list sessions: %w", errors.ErrInternalServer, err)
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one session of a user: its refresh token family is
+// revoked and every access token of the session denied until it expires.
+func (s *AuthServiceImpl) RevokeSession(ctx context.Context, email string, id string) error {
+	session, err := s.tokenStore.GetSession(ctx, id)
+	if err != nil {
+		if cerr.Is(err, store.ErrSessionNotFound) {
+			return err
+		}
+		return fmt.Errorf("%w: get session: %w", errors.ErrInternalServer, err)
+	}
+	// someone else's session is as good as a missing one
+	if session.UserEmail != email {
+		return store.ErrSessionNotFound
+	}
+
+	if err := s.tokenStore.RevokeFamily(ctx, session.ID); err != nil {
+		return fmt.Errorf("%w: revoke token family: %w", errors.ErrInternalServer, err)
+	}
+	// access tokens signed before the latest refresh are still valid too,
+	// so the whole session is denied for as long as any of them can live
+	if err := s.tokenStore.DenySession(ctx, session.ID, jwttypes.AccessTokenDuration); err != nil {
+		return fmt.Errorf("%w: deny session: %w", errors.ErrInternalServer, err)
+	}
+	if err := s.tokenStore.DeleteSession(ctx, email, session.ID); err != nil {
+		return fmt.Errorf("%w: delete session: %w", errors.ErrInternalServer, err)
+	}
+	return nil
+}
+
+// recordSession stores the use of a session by client, creating the
+// session on its first use.
+func (s *AuthServiceImpl) recordSession(ctx context.Context, email string, id string, client types.ClientInfo) error {
+	now := time.Now().UTC()
+
+	session, err := s.tokenStore.GetSession(ctx, id)
+	if err != nil {
+		if !cerr.Is(err, store.ErrSessionNotFound) {
+			return fmt.Errorf("%w: get session: %w", errors.ErrInternalServer, err)
+		}
+		// also families issued before sessions were recorded
+		session = &types.Session{ID: id, UserEmail: email, CreatedAt: now}
+	}
+
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		// cut before a rune, not in the middle of one
+		n := maxUserAgentLength
+		for n > 0 && !utf8.RuneStart(userAgent[n]) {
+			n--
+		}
+		userAgent = userAgent[:n]
+	}
+
+	session.UserAgent = userAgent
+	session.IP = client.IP
+	session.LastUsedAt = now
+
+	if err := s.tokenStore.SaveSession(ctx, *session, jwttypes.RefreshTokenDuration); err != nil {
+		return fmt.Errorf("%w: save session: %w", errors.ErrInternalServer, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	cerr "errors"
+	"fmt"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+)
+
+// SessionTerminator ends every session of a user
+type SessionTerminator interface {
+	LogoutAll(ctx context.Context, email string) error
+}
+
+// accountTakeover hands an unverified account to whoever just proved they
+// own its address. Whoever registered it before could not, so everything
+// they may have set up to keep access goes: the password, the second
+// factor, the sessions, API keys and linked identities.
+type accountTakeover struct {
+	userStore     store.UserStore
+	apiKeyStore   store.APIKeyStore
+	identityStore store.IdentityStore
+	sessions      SessionTerminator
+}
+
+// takeOver verifies the account of email and replaces its password with
+// password, a hash or "" for none
+func (t accountTakeover) takeOver(ctx context.Context, email string, password string) error {
+	verified, mfaEnabled := true, false
+	noSecret, noCodes := "", []string{}
+	if err := t.userStore.Update(ctx, email, store.UserUpdate{
+		Verified:         &verified,
+		Password:         &password,
+		MFAEnabled:       &mfaEnabled,
+		MFASecret:        &noSecret,
+		MFARecoveryCodes: &noCodes,
+	}); err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+
+	if err := t.sessions.LogoutAll(ctx, email); err != nil {
+		return err
+	}
+
+	apiKeys, err := t.apiKeyStore.ListByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: list api keys: %w", errors.ErrInternalServer, err)
+	}
+	for _, key := range apiKeys {
+		if err := t.apiKeyStore.Delete(ctx, email, key.ID); err != nil && !cerr.Is(err, store.ErrAPIKeyNotFound) {
+			return fmt.Errorf("%w: delete api key: %w", errors.ErrInternalServer, err)
+		}
+	}
+
+	identities, err := t.identityStore.ListByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: list identities: %w", errors.ErrInternalServer, err)
+	}
+	for _, identity := range identities {
+		if err := t.identityStore.Delete(ctx, email, identity.Provider, identity.ProviderID); err != nil && !cerr.Is(err, store.ErrIdentityNotFound) {
+			return fmt.Errorf("%w: delete identity: %w", errors.ErrInternalServer, err)
+		}
+	}
+	return nil
+}
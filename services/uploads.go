@@ -2,11 +2,14 @@ package services
 
 import (
 	"context"
+	cerr "errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	pb "github.com/Yulian302/lfusys-services-commons/api"
 	"github.com/Yulian302/lfusys-services-commons/errors"
+	"github.com/Yulian302/lfusys-services-gateway/logging"
 	"github.com/Yulian302/lfusys-services-gateway/store"
 	uploadstypes "github.com/Yulian302/lfusys-services-gateway/uploads/types"
 	"github.com/sony/gobreaker/v2"
@@ -14,9 +17,17 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// how many stale uploads one reaper run looks at
+const staleBatchSize = 500
+
+var ErrUploadFinished = cerr.New("upload is no longer in progress")
+
 type UploadsService interface {
-	StartUpload(ctx context.Context, email string, fileSize int64) (*uploadstypes.UploadResponse, error)
-	GetUploadStatus(ctx context.Context, uploadID string) (*uploadstypes.UploadStatusResponse, error)
+	StartUpload(ctx context.Context, email string, roles []string, fileSize int64) (*uploadstypes.UploadResponse, error)
+	GetUploadStatus(ctx context.Context, email string, uploadID string) (*uploadstypes.UploadStatusResponse, error)
+	ListUploads(ctx context.Context, email string) (*uploadstypes.UploadList, error)
+	CancelUpload(ctx context.Context, email string, uploadID string) error
+	Usage(ctx context.Context, email string, roles []string) (*uploadstypes.StorageUsage, error)
 }
 
 type UploadsServiceImpl struct {
@@ -24,6 +35,30 @@ type UploadsServiceImpl struct {
 	clientStub   pb.UploaderClient
 	breaker      *gobreaker.CircuitBreaker[*pb.UploadReply]
 	maxFileSize  int64
+
+	// without activity tracking abandoned uploads are never expired
+	Activity store.UploadActivityStore
+
+	// uploads a user may have pending or in progress at once
+	ConcurrencyLimit RoleLimits
+
+	// without a quota only maxFileSize limits what a user can store
+	Quota UploadQuota
+}
+
+// UploadLimitError is returned when a user already has as many active
+// uploads as allowed. It matches errors.ErrSessionConflict.
+type UploadLimitError struct {
+	Limit     int64
+	UploadIDs []string
+}
+
+func (e *UploadLimitError) Error() string {
+	return fmt.Sprintf("%s: %d of %d uploads in progress", errors.ErrSessionConflict, len(e.UploadIDs), e.Limit)
+}
+
+func (e *UploadLimitError) Unwrap() error {
+	return errors.ErrSessionConflict
 }
 
 func NewUploadsService(uploadsStore store.UploadsStore, cb pb.UploaderClient, breaker *gobreaker.CircuitBreaker[*pb.UploadReply]) *UploadsServiceImpl {
@@ -35,7 +70,7 @@ func NewUploadsService(uploadsStore store.UploadsStore, cb pb.UploaderClient, br
 	}
 }
 
-func (s *UploadsServiceImpl) StartUpload(ctx context.Context, email string, fileSize int64) (*uploadstypes.UploadResponse, error) {
+func (s *UploadsServiceImpl) StartUpload(ctx context.Context, email string, roles []string, fileSize int64) (*uploadstypes.UploadResponse, error) {
 	if fileSize <= 0 {
 		return nil, fmt.Errorf("%w", errors.ErrFileSizeInvalid)
 	}
@@ -44,6 +79,18 @@ func (s *UploadsServiceImpl) StartUpload(ctx context.Context, email string, file
 		return nil, fmt.Errorf("%w", errors.ErrFileSizeExceeded)
 	}
 
+	if err := s.checkConcurrency(ctx, email, roles); err != nil {
+		return nil, err
+	}
+
+	var reservationID string
+	if s.Quota != nil {
+		var err error
+		if reservationID, err = s.Quota.Reserve(ctx, email, roles, fileSize); err != nil {
+			return nil, err
+		}
+	}
+
 	res, err := s.breaker.Execute(func() (*pb.UploadReply, error) {
 		grpcCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
 		defer cancel()
@@ -55,6 +102,8 @@ func (s *UploadsServiceImpl) StartUpload(ctx context.Context, email string, file
 	})
 
 	if err != nil {
+		s.releaseQuota(ctx, email, reservationID)
+
 		if status.Code(err) == codes.ResourceExhausted {
 			return nil, fmt.Errorf("%w", errors.ErrFileSizeExceeded)
 		}
@@ -64,6 +113,20 @@ func (s *UploadsServiceImpl) StartUpload(ctx context.Context, email string, file
 		return nil, fmt.Errorf("%w", errors.ErrGrpcFailed)
 	}
 
+	if s.Quota != nil {
+		// an unconfirmed reservation lapses after a minute and the upload
+		// would no longer count against the quota, so it is called off
+		if err := s.Quota.Confirm(ctx, email, reservationID, res.UploadId); err != nil {
+			if cancelErr := s.uploadsStore.Finish(ctx, res.UploadId, uploadstypes.StatusCancelled); cancelErr != nil {
+				logging.FromContext(ctx).Error("could not cancel unreserved upload", slog.String("upload_id", res.UploadId), slog.Any("error", cancelErr))
+			}
+			s.releaseQuota(ctx, email, reservationID)
+			return nil, err
+		}
+	}
+
+	s.touch(ctx, res.UploadId)
+
 	return &uploadstypes.UploadResponse{
 		TotalChunks: res.TotalChunks,
 		UploadUrls:  res.UploadUrls,
@@ -71,7 +134,37 @@ func (s *UploadsServiceImpl) StartUpload(ctx context.Context, email string, file
 	}, nil
 }
 
-func (s *UploadsServiceImpl) GetUploadStatus(ctx context.Context, uploadID string) (*uploadstypes.UploadStatusResponse, error) {
+// checkConcurrency fails with an UploadLimitError if email may not start
+// another upload. Two uploads started at the same moment may both pass.
+func (s *UploadsServiceImpl) checkConcurrency(ctx context.Context, email string, roles []string) error {
+	limit := s.ConcurrencyLimit.For(roles)
+	if limit <= 0 {
+		return nil
+	}
+
+	uploads, err := s.uploadsStore.ListActive(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: list uploads: %w", errors.ErrInternalServer, err)
+	}
+	if int64(len(uploads)) < limit {
+		return nil
+	}
+
+	ids := make([]string, len(uploads))
+	for i, upload := range uploads {
+		ids[i] = upload.UploadId
+	}
+	return &UploadLimitError{Limit: limit, UploadIDs: ids}
+}
+
+// GetUploadStatus asks the session service about an upload of email.
+// Uploads of other users are reported as not found.
+func (s *UploadsServiceImpl) GetUploadStatus(ctx context.Context, email string, uploadID string) (*uploadstypes.UploadStatusResponse, error) {
+	upload, err := s.ownUpload(ctx, email, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
 	uploadStatusOut, err := s.clientStub.GetUploadStatus(ctx, &pb.UploadID{
 		UploadId: uploadID,
 	})
@@ -84,9 +177,154 @@ func (s *UploadsServiceImpl) GetUploadStatus(ctx context.Context, uploadID strin
 		}
 		return nil, fmt.Errorf("could not get upload status: %w", err)
 	}
+	// clients poll the status while they upload the chunks to storage
+	if upload.Active() {
+		s.touch(ctx, uploadID)
+	}
 	return &uploadstypes.UploadStatusResponse{
 		Status:   uploadStatusOut.Status,
 		Progress: uploadStatusOut.Progress,
 		Message:  uploadStatusOut.Message,
 	}, nil
 }
+
+// ListUploads returns the uploads of email that are pending or in progress
+func (s *UploadsServiceImpl) ListUploads(ctx context.Context, email string) (*uploadstypes.UploadList, error) {
+	uploads, err := s.uploadsStore.ListActive(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: list uploads: %w", errors.ErrInternalServer, err)
+	}
+	return &uploadstypes.UploadList{Uploads: uploads}, nil
+}
+
+// CancelUpload marks an active upload of email cancelled and releases its
+// storage. The session service is not told, it has no RPC to abort uploads yet.
+func (s *UploadsServiceImpl) CancelUpload(ctx context.Context, email string, uploadID string) error {
+	upload, err := s.ownUpload(ctx, email, uploadID)
+	if err != nil {
+		return err
+	}
+	if !upload.Active() {
+		return ErrUploadFinished
+	}
+
+	if err := s.uploadsStore.Finish(ctx, uploadID, uploadstypes.StatusCancelled); err != nil {
+		if cerr.Is(err, store.ErrUploadNotActive) {
+			return ErrUploadFinished
+		}
+		return fmt.Errorf("%w: finish upload: %w", errors.ErrInternalServer, err)
+	}
+	s.releaseQuota(ctx, email, uploadID)
+	s.forget(ctx, uploadID)
+	return nil
+}
+
+// ExpireStale marks the active uploads nobody started or polled for longer
+// than ttl expired, releases their storage and returns how many it expired.
+// Each run looks at most at staleBatchSize uploads, the rest wait for the
+// next one.
+func (s *UploadsServiceImpl) ExpireStale(ctx context.Context, ttl time.Duration) (int, error) {
+	if s.Activity == nil {
+		return 0, nil
+	}
+
+	ids, err := s.Activity.Stale(ctx, time.Now().Add(-ttl), staleBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("list stale uploads: %w", err)
+	}
+
+	expired := 0
+	for _, id := range ids {
+		upload, err := s.uploadsStore.Get(ctx, id)
+		if err != nil {
+			if cerr.Is(err, store.ErrUploadNotFound) {
+				s.forget(ctx, id)
+				continue
+			}
+			logging.FromContext(ctx).Error("could not get stale upload", slog.String("upload_id", id), slog.Any("error", err))
+			continue
+		}
+		// completed or cancelled in the meantime
+		if !upload.Active() {
+			s.forget(ctx, id)
+			continue
+		}
+
+		if err := s.uploadsStore.Finish(ctx, id, uploadstypes.StatusExpired); err != nil {
+			if !cerr.Is(err, store.ErrUploadNotActive) {
+				logging.FromContext(ctx).Error("could not expire upload", slog.String("upload_id", id), slog.Any("error", err))
+				continue
+			}
+		} else {
+			expired++
+		}
+		s.releaseQuota(ctx, upload.UserEmail, id)
+		s.forget(ctx, id)
+	}
+	return expired, nil
+}
+
+// Usage reports the storage and uploads email takes up and may take up
+func (s *UploadsServiceImpl) Usage(ctx context.Context, email string, roles []string) (*uploadstypes.StorageUsage, error) {
+	usage := &uploadstypes.StorageUsage{}
+	if s.Quota != nil {
+		var err error
+		if usage, err = s.Quota.Usage(ctx, email, roles); err != nil {
+			return nil, err
+		}
+	}
+
+	uploads, err := s.uploadsStore.ListActive(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: list uploads: %w", errors.ErrInternalServer, err)
+	}
+	usage.ActiveUploads = len(uploads)
+	usage.UploadLimit = s.ConcurrencyLimit.For(roles)
+	return usage, nil
+}
+
+// releaseQuota gives back the storage reserved under id. Failures are only
+// logged, the reservation is dropped anyway once the upload is inactive.
+func (s *UploadsServiceImpl) releaseQuota(ctx context.Context, email string, id string) {
+	if s.Quota == nil || id == "" {
+		return
+	}
+	if err := s.Quota.Release(ctx, email, id); err != nil {
+		logging.FromContext(ctx).Error("could not release storage reservation", slog.String("id", id), slog.Any("error", err))
+	}
+}
+
+// touch records that uploadID was just used, keeping the reaper away from it
+func (s *UploadsServiceImpl) touch(ctx context.Context, uploadID string) {
+	if s.Activity == nil {
+		return
+	}
+	if err := s.Activity.Touch(ctx, uploadID, time.Now()); err != nil {
+		logging.FromContext(ctx).Error("could not record upload activity", slog.String("upload_id", uploadID), slog.Any("error", err))
+	}
+}
+
+// forget stops tracking an upload that is no longer active
+func (s *UploadsServiceImpl) forget(ctx context.Context, uploadID string) {
+	if s.Activity == nil {
+		return
+	}
+	if err := s.Activity.Remove(ctx, uploadID); err != nil {
+		logging.FromContext(ctx).Error("could not stop tracking upload", slog.String("upload_id", uploadID), slog.Any("error", err))
+	}
+}
+
+// ownUpload returns the upload if it belongs to email
+func (s *UploadsServiceImpl) ownUpload(ctx context.Context, email string, uploadID string) (*uploadstypes.Upload, error) {
+	upload, err := s.uploadsStore.Get(ctx, uploadID)
+	if err != nil {
+		if cerr.Is(err, store.ErrUploadNotFound) {
+			return nil, fmt.Errorf("%w", errors.ErrSessionNotFound)
+		}
+		return nil, fmt.Errorf("%w: get upload: %w", errors.ErrInternalServer, err)
+	}
+	if upload.UserEmail != email {
+		return nil, fmt.Errorf("%w", errors.ErrSessionNotFound)
+	}
+	return upload, nil
+}
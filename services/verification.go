@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	cerr "errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/errors"
+	jwttypes "github.com/Yulian302/lfusys-services-commons/jwt"
+	"github.com/Yulian302/lfusys-services-commons/ratelimit"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/Yulian302/lfusys-services-gateway/mail"
+	"github.com/Yulian302/lfusys-services-gateway/store"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var ErrTooManyRequests = cerr.New("too many requests")
+
+const (
+	verificationTokenType     = "email_verification"
+	verificationTokenDuration = 24 * time.Hour
+
+	verificationResendLimit  = 3
+	verificationResendWindow = time.Hour
+)
+
+type VerificationService interface {
+	SendVerification(ctx context.Context, email string) error
+	Verify(ctx context.Context, token string) error
+}
+
+type VerificationServiceImpl struct {
+	userStore   store.UserStore
+	takeover    accountTakeover
+	mailer      mail.Mailer
+	limiter     ratelimit.RateLimiter
+	secret      []byte
+	frontendURL string
+}
+
+func NewVerificationService(userStore store.UserStore, apiKeyStore store.APIKeyStore, identityStore store.IdentityStore, sessions SessionTerminator, mailer mail.Mailer, limiter ratelimit.RateLimiter, secret string, frontendURL string) *VerificationServiceImpl {
+	return &VerificationServiceImpl{
+		userStore: userStore,
+		takeover: accountTakeover{
+			userStore:     userStore,
+			apiKeyStore:   apiKeyStore,
+			identityStore: identityStore,
+			sessions:      sessions,
+		},
+		mailer:      mailer,
+		limiter:     limiter,
+		secret:      deriveKey(secret, verificationTokenType),
+		frontendURL: frontendURL,
+	}
+}
+
+// SendVerification mails a verification link. Unknown and already verified
+// addresses are silently ignored so the endpoint cannot be used to probe accounts.
+func (s *VerificationServiceImpl) SendVerification(ctx context.Context, email string) error {
+	if s.limiter != nil {
+		key := fmt.Sprintf("rate:verify:%s", email)
+		count, err := s.limiter.Incr(ctx, key)
+		if err == nil {
+			if count == 1 {
+				_ = s.limiter.Expire(ctx, key, verificationResendWindow)
+			}
+			if count > verificationResendLimit {
+				return ErrTooManyRequests
+			}
+		}
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return nil
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	if user.Verified {
+		return nil
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, types.Claims{
+		JWTClaims: jwttypes.JWTClaims{
+			Issuer:    "lfusys",
+			Subject:   user.Email,
+			ExpiresAt: time.Now().Add(verificationTokenDuration).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Type:      verificationTokenType,
+			JTI:       uuid.NewString(),
+		},
+	}).SignedString(s.secret)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrTokenSignature, err)
+	}
+
+	link := s.frontendURL + "/verify-email?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nplease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours.",
+			user.Name,
+			link,
+		),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: send verification mail: %w", errors.ErrInternalServer, err)
+	}
+
+	return nil
+}
+
+// Verify confirms the address a verification token was sent to. The
+// account goes to the owner of the address without the password or
+// anything else its registrant set up, the owner sets a new password
+// through a reset or signs in with a magic link.
+func (s *VerificationServiceImpl) Verify(ctx context.Context, token string) error {
+	parsedToken, err := jwt.ParseWithClaims(token, &types.Claims{}, func(t *jwt.Token) (any, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !parsedToken.Valid {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidToken, err)
+	}
+
+	claims := parsedToken.Claims.(*types.Claims)
+	if claims.Type != verificationTokenType {
+		return errors.ErrInvalidTokenType
+	}
+
+	user, err := s.userStore.GetByEmail(ctx, claims.Subject)
+	if err != nil {
+		if cerr.Is(err, errors.ErrUserNotFound) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errors.ErrInternalServer, err)
+	}
+	if user.Verified {
+		return nil
+	}
+
+	return s.takeover.takeOver(ctx, user.Email, "")
+}
+
+// deriveKey gives every token purpose its own signing key, so e.g. a
+// verification token can never pass as an access token
+func deriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,253 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// email verification policies
+const (
+	VerifyNone    = "none"
+	VerifyUploads = "uploads"
+	VerifyLogin   = "login"
+)
+
+// Settings holds gateway specific configuration that is not shared with
+// the other lfusys services
+type Settings struct {
+	APIKeysTableName    string
+	IdentitiesTableName string
+
+	// which actions unverified users may not perform: none, uploads or login
+	VerificationPolicy string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	// only log that mails would be sent, for development without SMTP_HOST
+	MailLogOnly bool
+
+	OIDCProviders []OIDCProvider
+
+	// origins, optionally with a path prefix, a login may return to besides the frontend
+	ReturnToAllowlist []string
+
+	// JSON list of asymmetric JWT signing keys, inline or as a file. Without
+	// keys tokens are signed with JWT_SECRET_KEY.
+	JWTKeys     string
+	JWTKeysFile string
+	// accept HS256 tokens without kid while signing with the keys, only
+	// meant for the switch to the keys
+	JWTAcceptLegacyTokens bool
+
+	// upload sessions the client did not start or poll for this long
+	// are expired, checked every UploadReaperInterval
+	UploadSessionTTL     time.Duration
+	UploadReaperInterval time.Duration
+
+	// how many uploads a user may have pending or in progress at once, 0 (the
+	// default) for no limit. Roles can have their own, e.g.
+	// UPLOAD_CONCURRENCY_LIMITS=admin=5. API keys get their owner's.
+	UploadConcurrencyLimit  int64
+	UploadConcurrencyLimits map[string]int64
+
+	// bytes a user may store including uploads in progress, 0 (the default)
+	// for no limit. Sizes take a unit, e.g. STORAGE_QUOTA=50GiB or
+	// STORAGE_QUOTAS=pro=1TB,admin=0. With a quota uploads need the session
+	// service to list the user's files and fail while it cannot.
+	StorageQuota  int64
+	StorageQuotas map[string]int64
+}
+
+// OIDCProvider configures a generic OpenID Connect login, e.g. Okta,
+// Azure AD or Keycloak
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scopes       []string
+}
+
+func Load() Settings {
+	return Settings{
+		APIKeysTableName:    getEnv("DYNAMODB_API_KEYS_TABLE_NAME", "api_keys"),
+		IdentitiesTableName: getEnv("DYNAMODB_IDENTITIES_TABLE_NAME", "identities"),
+
+		VerificationPolicy: getEnv("EMAIL_VERIFICATION_POLICY", VerifyNone),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		MailFrom:     getEnv("MAIL_FROM", "no-reply@lfusys.local"),
+		MailLogOnly:  getEnv("MAIL_LOG_ONLY", "false") == "true",
+
+		OIDCProviders: loadOIDCProviders(),
+
+		ReturnToAllowlist: strings.Split(getEnv("RETURN_TO_ALLOWLIST", ""), ","),
+
+		JWTKeys:               getEnv("JWT_KEYS", ""),
+		JWTKeysFile:           getEnv("JWT_KEYS_FILE", ""),
+		JWTAcceptLegacyTokens: getEnv("JWT_ACCEPT_LEGACY_TOKENS", "false") == "true",
+
+		UploadSessionTTL:     getDuration("UPLOAD_SESSION_TTL", 24*time.Hour),
+		UploadReaperInterval: getDuration("UPLOAD_REAPER_INTERVAL", 10*time.Minute),
+
+		UploadConcurrencyLimit:  getInt("UPLOAD_CONCURRENCY_LIMIT", 0),
+		UploadConcurrencyLimits: getRoleLimits("UPLOAD_CONCURRENCY_LIMITS", parseInt),
+
+		StorageQuota:  getBytes("STORAGE_QUOTA", 0),
+		StorageQuotas: getRoleLimits("STORAGE_QUOTAS", parseBytes),
+	}
+}
+
+// Validate rejects values that would otherwise be misread silently
+func (s Settings) Validate() error {
+	switch s.VerificationPolicy {
+	case VerifyNone, VerifyUploads, VerifyLogin:
+	default:
+		return fmt.Errorf("unknown EMAIL_VERIFICATION_POLICY %q, use %s, %s or %s", s.VerificationPolicy, VerifyNone, VerifyUploads, VerifyLogin)
+	}
+	if s.SMTPHost == "" && !s.MailLogOnly {
+		return errors.New("SMTP_HOST is not set, set MAIL_LOG_ONLY=true to run without sending mails")
+	}
+	return nil
+}
+
+// loadOIDCProviders reads OIDC_PROVIDERS=okta,keycloak and for every name
+// OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URI and _SCOPES
+func loadOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		providers = append(providers, OIDCProvider{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURI:  getEnv(prefix+"REDIRECT_URI", ""),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		})
+	}
+	return providers
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	v := getEnv(key, "")
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s %q, using %s", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+func getInt(key string, fallback int64) int64 {
+	v := getEnv(key, "")
+	if v == "" {
+		return fallback
+	}
+	n, err := parseInt(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+func getBytes(key string, fallback int64) int64 {
+	v := getEnv(key, "")
+	if v == "" {
+		return fallback
+	}
+	n, err := parseBytes(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// getRoleLimits reads comma separated role=limit pairs, parsing every limit with parse
+func getRoleLimits(key string, parse func(string) (int64, error)) map[string]int64 {
+	limits := map[string]int64{}
+	for _, pair := range strings.Split(getEnv(key, ""), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		role, value, found := strings.Cut(pair, "=")
+		n, err := parse(strings.TrimSpace(value))
+		if !found || err != nil {
+			log.Printf("ignoring invalid %s entry %q", key, pair)
+			continue
+		}
+		limits[strings.TrimSpace(role)] = n
+	}
+	return limits
+}
+
+func parseInt(s string) (int64, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+var byteUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// parseBytes reads a size like 500MB or 50GiB
+func parseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	unit, ok := byteUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit in %q", s)
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n * unit, nil
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/Yulian302/lfusys-services-commons/config"
+	"github.com/Yulian302/lfusys-services-gateway/settings"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -24,6 +25,7 @@ type App struct {
 	Redis    *redis.Client
 
 	Config    config.Config
+	Settings  settings.Settings
 	AwsConfig aws.Config
 
 	Services       *Services
@@ -37,6 +39,11 @@ func SetupApp() (*App, error) {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
+	sets := settings.Load()
+	if err := sets.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid settings: %w", err)
+	}
+
 	awsCfg, err := initAWS(*cfg.AWSConfig)
 	if err != nil {
 		return nil, err
@@ -57,10 +64,12 @@ func SetupApp() (*App, error) {
 		Redis:    rdb,
 
 		Config:    cfg,
+		Settings:  sets,
 		AwsConfig: awsCfg,
 	}
 
 	app.Services = BuildServices(app)
+	app.Services.UploadReaper.Start()
 
 	return app, nil
 }
@@ -112,6 +121,12 @@ func (a *App) Shutdown(ctx context.Context) error {
 		}
 	}
 
+	if a.Services != nil && a.Services.UploadReaper != nil {
+		if err := a.Services.UploadReaper.Stop(ctx); err != nil {
+			log.Printf("upload reaper stop error: %v", err)
+		}
+	}
+
 	if a.Services != nil {
 		if err := a.Services.Shutdown(ctx); err != nil {
 			log.Printf("services shutdown error: %v", err)
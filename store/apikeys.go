@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/health"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+type APIKeyStore interface {
+	Create(ctx context.Context, key types.APIKey) error
+	GetByID(ctx context.Context, id string) (*types.APIKey, error)
+	ListByEmail(ctx context.Context, email string) ([]types.APIKey, error)
+	Delete(ctx context.Context, email string, id string) error
+	TouchLastUsed(ctx context.Context, id string, at time.Time) error
+
+	health.ReadinessCheck
+}
+
+type DynamoDbAPIKeyStore struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func NewAPIKeyStore(dbClient *dynamodb.Client, tableName string) *DynamoDbAPIKeyStore {
+	return &DynamoDbAPIKeyStore{
+		Client:    dbClient,
+		TableName: tableName,
+	}
+}
+
+func (s *DynamoDbAPIKeyStore) IsReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	_, err := s.Client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.TableName),
+	})
+
+	return err
+}
+
+func (s *DynamoDbAPIKeyStore) Name() string {
+	return "APIKeyStore[api_keys]"
+}
+
+func (s *DynamoDbAPIKeyStore) Create(ctx context.Context, key types.APIKey) error {
+	item, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	return err
+}
+
+func (s *DynamoDbAPIKeyStore) GetByID(ctx context.Context, id string) (*types.APIKey, error) {
+	res, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"id": &dynamoTypes.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Item == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	var key types.APIKey
+	if err := attributevalue.UnmarshalMap(res.Item, &key); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (s *DynamoDbAPIKeyStore) ListByEmail(ctx context.Context, email string) ([]types.APIKey, error) {
+	out, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		IndexName:              aws.String("user_email-index"),
+		KeyConditionExpression: aws.String("user_email = :email"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":email": &dynamoTypes.AttributeValueMemberS{Value: email},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]types.APIKey, 0, len(out.Items))
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s *DynamoDbAPIKeyStore) Delete(ctx context.Context, email string, id string) error {
+	_, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"id": &dynamoTypes.AttributeValueMemberS{Value: id},
+		},
+		ConditionExpression: aws.String("user_email = :email"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":email": &dynamoTypes.AttributeValueMemberS{Value: email},
+		},
+	})
+	if err != nil {
+		var ccf *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *DynamoDbAPIKeyStore) TouchLastUsed(ctx context.Context, id string, at time.Time) error {
+	lastUsed, err := attributevalue.Marshal(at)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"id": &dynamoTypes.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET last_used_at = :at"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":at": lastUsed,
+		},
+	})
+	return err
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-commons/health"
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrIdentityExists   = errors.New("identity already linked")
+)
+
+type IdentityStore interface {
+	Create(ctx context.Context, identity types.Identity) error
+	Get(ctx context.Context, provider, providerID string) (*types.Identity, error)
+	ListByEmail(ctx context.Context, email string) ([]types.Identity, error)
+	Delete(ctx context.Context, email, provider, providerID string) error
+
+	health.ReadinessCheck
+}
+
+type DynamoDbIdentityStore struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func NewIdentityStore(dbClient *dynamodb.Client, tableName string) *DynamoDbIdentityStore {
+	return &DynamoDbIdentityStore{
+		Client:    dbClient,
+		TableName: tableName,
+	}
+}
+
+func (s *DynamoDbIdentityStore) IsReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	_, err := s.Client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.TableName),
+	})
+
+	return err
+}
+
+func (s *DynamoDbIdentityStore) Name() string {
+	return "IdentityStore[identities]"
+}
+
+func (s *DynamoDbIdentityStore) Create(ctx context.Context, identity types.Identity) error {
+	identity.ID = types.IdentityID(identity.Provider, identity.ProviderID)
+
+	item, err := attributevalue.MarshalMap(identity)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		var ccf *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrIdentityExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *DynamoDbIdentityStore) Get(ctx context.Context, provider, providerID string) (*types.Identity, error) {
+	res, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"id": &dynamoTypes.AttributeValueMemberS{Value: types.IdentityID(provider, providerID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Item == nil {
+		return nil, ErrIdentityNotFound
+	}
+
+	var identity types.Identity
+	if err := attributevalue.UnmarshalMap(res.Item, &identity); err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (s *DynamoDbIdentityStore) ListByEmail(ctx context.Context, email string) ([]types.Identity, error) {
+	out, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		IndexName:              aws.String("user_email-index"),
+		KeyConditionExpression: aws.String("user_email = :email"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":email": &dynamoTypes.AttributeValueMemberS{Value: email},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	identities := make([]types.Identity, 0, len(out.Items))
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &identities); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+func (s *DynamoDbIdentityStore) Delete(ctx context.Context, email, provider, providerID string) error {
+	_, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"id": &dynamoTypes.AttributeValueMemberS{Value: types.IdentityID(provider, providerID)},
+		},
+		ConditionExpression: aws.String("user_email = :email"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":email": &dynamoTypes.AttributeValueMemberS{Value: email},
+		},
+	})
+	if err != nil {
+		var ccf *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrIdentityNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginFailPrefix = "login:fail:"
+	loginLockPrefix = "login:lock:"
+)
+
+// LoginAttemptStore counts failed logins per key, e.g. an account or a
+// client IP, and keeps temporary lockouts
+type LoginAttemptStore interface {
+	// Fail records a failed login and returns the failures within window
+	Fail(ctx context.Context, key string, window time.Duration) (int64, error)
+	Reset(ctx context.Context, key string) error
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// LockedFor returns how long key stays locked, 0 if it is not
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+}
+
+type RedisLoginAttemptStore struct {
+	client *redis.Client
+}
+
+func NewRedisLoginAttemptStore(client *redis.Client) *RedisLoginAttemptStore {
+	return &RedisLoginAttemptStore{
+		client: client,
+	}
+}
+
+// failScript increments KEYS[1] and starts its window of ARGV[1] ms on the first failure
+var failScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+func (s *RedisLoginAttemptStore) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return failScript.Run(ctx, s.client, []string{loginFailPrefix + key}, window.Milliseconds()).Int64()
+}
+
+func (s *RedisLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, loginFailPrefix+key).Err()
+}
+
+func (s *RedisLoginAttemptStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	return s.client.Set(ctx, loginLockPrefix+key, "1", d).Err()
+}
+
+func (s *RedisLoginAttemptStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, loginLockPrefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -2 for a missing key, -1 for a key without expiry which Lock never creates
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
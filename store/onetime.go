@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const oneTimeTokenPrefix = "token:once:"
+
+var ErrOneTimeTokenNotFound = errors.New("token not found or already used")
+
+// OneTimeTokenStore keeps short lived single-use tokens, e.g. for password
+// resets. Tokens are looked up by their hash, the plain token is never stored.
+type OneTimeTokenStore interface {
+	Save(ctx context.Context, purpose, tokenHash, email string, ttl time.Duration) error
+	Consume(ctx context.Context, purpose, tokenHash string) (string, error)
+}
+
+type RedisOneTimeTokenStore struct {
+	client *redis.Client
+}
+
+func NewRedisOneTimeTokenStore(client *redis.Client) *RedisOneTimeTokenStore {
+	return &RedisOneTimeTokenStore{
+		client: client,
+	}
+}
+
+func (s *RedisOneTimeTokenStore) Save(ctx context.Context, purpose, tokenHash, email string, ttl time.Duration) error {
+	return s.client.Set(ctx, oneTimeTokenPrefix+purpose+":"+tokenHash, email, ttl).Err()
+}
+
+// Consume returns the email the token was issued for and deletes it in the same step
+func (s *RedisOneTimeTokenStore) Consume(ctx context.Context, purpose, tokenHash string) (string, error) {
+	email, err := s.client.GetDel(ctx, oneTimeTokenPrefix+purpose+":"+tokenHash).Result()
+	if err == redis.Nil {
+		return "", ErrOneTimeTokenNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return email, nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const quotaReservedPrefix = "quota:reserved:"
+
+// reservations outlive every upload session they are made for
+const quotaReservationTTL = 7 * 24 * time.Hour
+
+// QuotaStore keeps the bytes reserved by uploads in flight, per user and
+// keyed by an upload or reservation ID
+type QuotaStore interface {
+	// Reserve adds a reservation unless used, everything reserved so far
+	// and bytes together exceed limit. A limit of 0 or less is unlimited.
+	// It returns whether it reserved and the bytes reserved before.
+	Reserve(ctx context.Context, email, id string, bytes, used, limit int64) (bool, int64, error)
+	// Rename moves a reservation to a new ID, e.g. once the upload ID is known
+	Rename(ctx context.Context, email, from, to string) error
+	Release(ctx context.Context, email string, ids ...string) error
+	Reservations(ctx context.Context, email string) (map[string]int64, error)
+}
+
+type RedisQuotaStore struct {
+	client *redis.Client
+}
+
+func NewRedisQuotaStore(client *redis.Client) *RedisQuotaStore {
+	return &RedisQuotaStore{
+		client: client,
+	}
+}
+
+// reserveScript sums the reservations in KEYS[1] and adds ARGV[2] bytes as
+// field ARGV[1] if ARGV[3] used bytes plus all of it stay within ARGV[4]
+var reserveScript = redis.NewScript(`
+local reserved = 0
+for _, v in ipairs(redis.call('HVALS', KEYS[1])) do
+	reserved = reserved + tonumber(v)
+end
+local bytes, used, limit = tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+if limit > 0 and used + reserved + bytes > limit then
+	return {0, reserved}
+end
+redis.call('HSET', KEYS[1], ARGV[1], bytes)
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return {1, reserved}
+`)
+
+func (s *RedisQuotaStore) Reserve(ctx context.Context, email, id string, bytes, used, limit int64) (bool, int64, error) {
+	res, err := reserveScript.Run(ctx, s.client, []string{quotaReservedPrefix + email}, id, bytes, used, limit, quotaReservationTTL.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, res[1], nil
+}
+
+// renameScript moves field ARGV[1] of KEYS[1] to ARGV[2]
+var renameScript = redis.NewScript(`
+local bytes = redis.call('HGET', KEYS[1], ARGV[1])
+if bytes then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	redis.call('HSET', KEYS[1], ARGV[2], bytes)
+end
+return 0
+`)
+
+func (s *RedisQuotaStore) Rename(ctx context.Context, email, from, to string) error {
+	return renameScript.Run(ctx, s.client, []string{quotaReservedPrefix + email}, from, to).Err()
+}
+
+func (s *RedisQuotaStore) Release(ctx context.Context, email string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.client.HDel(ctx, quotaReservedPrefix+email, ids...).Err()
+}
+
+func (s *RedisQuotaStore) Reservations(ctx context.Context, email string) (map[string]int64, error) {
+	values, err := s.client.HGetAll(ctx, quotaReservedPrefix+email).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := make(map[string]int64, len(values))
+	for id, v := range values {
+		bytes, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		reservations[id] = bytes
+	}
+	return reservations, nil
+}
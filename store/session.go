@@ -2,14 +2,20 @@ package store
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+var ErrStateNotFound = errors.New("state not found")
+
+// SessionStore keeps short lived values of an OAuth login between the
+// redirect to the provider and its callback
 type SessionStore interface {
-	Create(ctx context.Context, key string) error
-	IsStateExists(ctx context.Context, key string) (bool, error)
+	Create(ctx context.Context, key string, value string, ttl time.Duration) error
+	// Take returns the value of key and deletes it, so every state can only be used once
+	Take(ctx context.Context, key string) (string, error)
 }
 
 type RedisStoreImpl struct {
@@ -22,22 +28,26 @@ func NewRedisStoreImpl(client *redis.Client) *RedisStoreImpl {
 	}
 }
 
-func (s *RedisStoreImpl) Create(ctx context.Context, key string) error {
-	cmd := s.client.SetNX(ctx, key, "1", time.Minute)
-	return cmd.Err()
+func (s *RedisStoreImpl) Create(ctx context.Context, key string, value string, ttl time.Duration) error {
+	ok, err := s.client.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("state already exists")
+	}
+	return nil
 }
 
-func (s *RedisStoreImpl) IsStateExists(ctx context.Context, key string) (bool, error) {
-	_, err := s.client.Get(ctx, key).Result()
+func (s *RedisStoreImpl) Take(ctx context.Context, key string) (string, error) {
+	value, err := s.client.GetDel(ctx, key).Result()
 	if err == redis.Nil {
-		return false, nil
+		return "", ErrStateNotFound
 	}
 	if err != nil {
-		return false, err
+		return "", err
 	}
-
-	_ = s.client.Del(ctx, key).Err()
-	return true, nil
+	return value, nil
 }
 
 func (s *RedisStoreImpl) Shutdown(ctx context.Context) error {
@@ -0,0 +1,278 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Yulian302/lfusys-services-gateway/auth/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	refreshJtiPrefix    = "refresh:jti:"
+	refreshFamilyPrefix = "refresh:family:"
+	deniedJtiPrefix     = "token:denied:"
+	deniedSessionPrefix = "session:denied:"
+	generationPrefix    = "token:gen:"
+	sessionPrefix       = "refresh:session:"
+	userSessionsPrefix  = "refresh:sessions:"
+)
+
+var (
+	ErrTokenFamilyNotFound = errors.New("refresh token family not found")
+	ErrTokenReused         = errors.New("refresh token reused")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// TokenStore tracks refresh tokens as families: every refresh token
+// belongs to exactly one family, and only the most recently issued
+// token of a family may be exchanged.
+type TokenStore interface {
+	CreateFamily(ctx context.Context, familyID, jti string, ttl time.Duration) error
+	RotateFamily(ctx context.Context, oldJti, newJti string, ttl time.Duration) (string, error)
+	RevokeFamily(ctx context.Context, familyID string) error
+	FamilyOf(ctx context.Context, jti string) (string, error)
+
+	DenyToken(ctx context.Context, jti string, ttl time.Duration) error
+	IsTokenDenied(ctx context.Context, jti string) (bool, error)
+	// a denied session takes every access token carrying its id down with it
+	DenySession(ctx context.Context, id string, ttl time.Duration) error
+	IsSessionDenied(ctx context.Context, id string) (bool, error)
+	Generation(ctx context.Context, email string) (int64, error)
+	BumpGeneration(ctx context.Context, email string) (int64, error)
+
+	// sessions describe the families for their users, keyed by family id
+	SaveSession(ctx context.Context, session types.Session, ttl time.Duration) error
+	GetSession(ctx context.Context, id string) (*types.Session, error)
+	ListSessions(ctx context.Context, email string) ([]types.Session, error)
+	DeleteSession(ctx context.Context, email, id string) error
+}
+
+type RedisTokenStore struct {
+	client *redis.Client
+}
+
+func NewRedisTokenStore(client *redis.Client) *RedisTokenStore {
+	return &RedisTokenStore{
+		client: client,
+	}
+}
+
+// rotateScript swaps the current token of a family (KEYS[1]) from
+// ARGV[1] to ARGV[2] and maps the new jti (KEYS[2]) to the family ARGV[4].
+// It returns 1 on success, 0 if the family is gone and 2 if ARGV[1] is
+// not the current token, in which case the family is revoked.
+var rotateScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if not current then
+	return 0
+end
+if current ~= ARGV[1] then
+	redis.call('DEL', KEYS[1])
+	return 2
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+redis.call('SET', KEYS[2], ARGV[4], 'PX', ARGV[3])
+return 1
+`)
+
+func (s *RedisTokenStore) CreateFamily(ctx context.Context, familyID, jti string, ttl time.Duration) error {
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, refreshFamilyPrefix+familyID, jti, ttl)
+	pipe.Set(ctx, refreshJtiPrefix+jti, familyID, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisTokenStore) RotateFamily(ctx context.Context, oldJti, newJti string, ttl time.Duration) (string, error) {
+	familyID, err := s.FamilyOf(ctx, oldJti)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := rotateScript.Run(
+		ctx,
+		s.client,
+		[]string{refreshFamilyPrefix + familyID, refreshJtiPrefix + newJti},
+		oldJti, newJti, ttl.Milliseconds(), familyID,
+	).Int()
+	if err != nil {
+		return "", err
+	}
+
+	switch res {
+	case 1:
+		return familyID, nil
+	case 2:
+		return familyID, ErrTokenReused
+	default:
+		return familyID, ErrTokenFamilyNotFound
+	}
+}
+
+func (s *RedisTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	return s.client.Del(ctx, refreshFamilyPrefix+familyID).Err()
+}
+
+func (s *RedisTokenStore) FamilyOf(ctx context.Context, jti string) (string, error) {
+	familyID, err := s.client.Get(ctx, refreshJtiPrefix+jti).Result()
+	if err == redis.Nil {
+		return "", ErrTokenFamilyNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return familyID, nil
+}
+
+func (s *RedisTokenStore) DenyToken(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		// already expired, nothing to deny
+		return nil
+	}
+	return s.client.Set(ctx, deniedJtiPrefix+jti, "1", ttl).Err()
+}
+
+func (s *RedisTokenStore) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	n, err := s.client.Exists(ctx, deniedJtiPrefix+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *RedisTokenStore) DenySession(ctx context.Context, id string, ttl time.Duration) error {
+	return s.client.Set(ctx, deniedSessionPrefix+id, "1", ttl).Err()
+}
+
+func (s *RedisTokenStore) IsSessionDenied(ctx context.Context, id string) (bool, error) {
+	n, err := s.client.Exists(ctx, deniedSessionPrefix+id).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *RedisTokenStore) Generation(ctx context.Context, email string) (int64, error) {
+	gen, err := s.client.Get(ctx, generationPrefix+email).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return gen, err
+}
+
+func (s *RedisTokenStore) BumpGeneration(ctx context.Context, email string) (int64, error) {
+	return s.client.Incr(ctx, generationPrefix+email).Result()
+}
+
+// sessionRecord is the stored form of a session, including the fields
+// that are never sent to clients
+type sessionRecord struct {
+	ID         string    `json:"id"`
+	UserEmail  string    `json:"email"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// SaveSession creates or replaces a session. The per-user index is a
+// sorted set scored by expiry, so listing can skip sessions that expired.
+func (s *RedisTokenStore) SaveSession(ctx context.Context, session types.Session, ttl time.Duration) error {
+	data, err := json.Marshal(sessionRecord{
+		ID:         session.ID,
+		UserEmail:  session.UserEmail,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	index := userSessionsPrefix + session.UserEmail
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, sessionPrefix+session.ID, data, ttl)
+	pipe.ZAdd(ctx, index, redis.Z{Score: float64(time.Now().Add(ttl).UnixMilli()), Member: session.ID})
+	// no session outlives the one saved last
+	pipe.PExpire(ctx, index, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisTokenStore) GetSession(ctx context.Context, id string) (*types.Session, error) {
+	data, err := s.client.Get(ctx, sessionPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(data)
+}
+
+func (s *RedisTokenStore) ListSessions(ctx context.Context, email string) ([]types.Session, error) {
+	index := userSessionsPrefix + email
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := s.client.ZRemRangeByScore(ctx, index, "-inf", now).Err(); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.client.ZRange(ctx, index, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []types.Session{}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionPrefix + id
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]types.Session, 0, len(values))
+	for _, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		session, err := decodeSession([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, nil
+}
+
+func (s *RedisTokenStore) DeleteSession(ctx context.Context, email, id string) error {
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, sessionPrefix+id)
+	pipe.ZRem(ctx, userSessionsPrefix+email, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func decodeSession(data []byte) (*types.Session, error) {
+	var record sessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &types.Session{
+		ID:         record.ID,
+		UserEmail:  record.UserEmail,
+		UserAgent:  record.UserAgent,
+		IP:         record.IP,
+		CreatedAt:  record.CreatedAt,
+		LastUsedAt: record.LastUsedAt,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// the session service does not record when an upload was last used, so
+// the gateway keeps that itself, scored by unix milliseconds
+const uploadActivityKey = "uploads:activity"
+
+// UploadActivityStore remembers when the gateway last saw each active upload used
+type UploadActivityStore interface {
+	Touch(ctx context.Context, uploadID string, at time.Time) error
+	// Stale returns at most limit uploads last used before before, oldest first
+	Stale(ctx context.Context, before time.Time, limit int64) ([]string, error)
+	Remove(ctx context.Context, uploadIDs ...string) error
+}
+
+type RedisUploadActivityStore struct {
+	client *redis.Client
+}
+
+func NewRedisUploadActivityStore(client *redis.Client) *RedisUploadActivityStore {
+	return &RedisUploadActivityStore{
+		client: client,
+	}
+}
+
+func (s *RedisUploadActivityStore) Touch(ctx context.Context, uploadID string, at time.Time) error {
+	return s.client.ZAdd(ctx, uploadActivityKey, redis.Z{Score: float64(at.UnixMilli()), Member: uploadID}).Err()
+}
+
+func (s *RedisUploadActivityStore) Stale(ctx context.Context, before time.Time, limit int64) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, uploadActivityKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(before.UnixMilli(), 10),
+		Count: limit,
+	}).Result()
+}
+
+func (s *RedisUploadActivityStore) Remove(ctx context.Context, uploadIDs ...string) error {
+	if len(uploadIDs) == 0 {
+		return nil
+	}
+	members := make([]any, len(uploadIDs))
+	for i, id := range uploadIDs {
+		members[i] = id
+	}
+	return s.client.ZRem(ctx, uploadActivityKey, members...).Err()
+}
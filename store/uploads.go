@@ -2,16 +2,28 @@ package store
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/Yulian302/lfusys-services-commons/health"
+	uploadstypes "github.com/Yulian302/lfusys-services-gateway/uploads/types"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+var (
+	ErrUploadNotFound  = errors.New("upload not found")
+	ErrUploadNotActive = errors.New("upload is not pending or in progress")
+)
+
 type UploadsStore interface {
 	FindExisting(ctx context.Context, email string) (bool, error)
+	ListActive(ctx context.Context, email string) ([]uploadstypes.Upload, error)
+	Get(ctx context.Context, uploadID string) (*uploadstypes.Upload, error)
+	// Finish moves an active upload to status, e.g. cancelled
+	Finish(ctx context.Context, uploadID string, status string) error
 
 	health.ReadinessCheck
 }
@@ -44,26 +56,92 @@ func (s *DynamoDbUploadsStore) Name() string {
 }
 
 func (s *DynamoDbUploadsStore) FindExisting(ctx context.Context, email string) (bool, error) {
-	out, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+	uploads, err := s.ListActive(ctx, email)
+	if err != nil {
+		return false, err
+	}
+	return len(uploads) > 0, nil
+}
+
+// ListActive returns the pending and in progress uploads of a user
+func (s *DynamoDbUploadsStore) ListActive(ctx context.Context, email string) ([]uploadstypes.Upload, error) {
+	uploads := []uploadstypes.Upload{}
+
+	paginator := dynamodb.NewQueryPaginator(s.Client, &dynamodb.QueryInput{
 		TableName:              &s.TableName,
 		IndexName:              aws.String("user_email-index"),
 		KeyConditionExpression: aws.String("user_email = :email"),
+		FilterExpression:       aws.String("#status IN (:pending, :in_progress)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
 		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
-			":email": &dynamoTypes.AttributeValueMemberS{Value: email},
+			":email":       &dynamoTypes.AttributeValueMemberS{Value: email},
+			":pending":     &dynamoTypes.AttributeValueMemberS{Value: uploadstypes.StatusPending},
+			":in_progress": &dynamoTypes.AttributeValueMemberS{Value: uploadstypes.StatusInProgress},
+		},
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var page []uploadstypes.Upload
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, page...)
+	}
+
+	return uploads, nil
+}
+
+func (s *DynamoDbUploadsStore) Get(ctx context.Context, uploadID string) (*uploadstypes.Upload, error) {
+	res, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"upload_id": &dynamoTypes.AttributeValueMemberS{Value: uploadID},
 		},
 	})
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	if len(out.Items) > 0 {
-		for _, item := range out.Items {
-			if status, exists := item["status"]; exists {
-				if statusStr := status.(*dynamoTypes.AttributeValueMemberS).Value; statusStr == "pending" || statusStr == "in_progress" {
-					return true, nil
-				}
-			}
-		}
+	if res.Item == nil {
+		return nil, ErrUploadNotFound
 	}
 
-	return false, nil
+	var upload uploadstypes.Upload
+	if err := attributevalue.UnmarshalMap(res.Item, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (s *DynamoDbUploadsStore) Finish(ctx context.Context, uploadID string, status string) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"upload_id": &dynamoTypes.AttributeValueMemberS{Value: uploadID},
+		},
+		UpdateExpression: aws.String("SET #status = :status"),
+		// the session service may have completed the upload in the meantime
+		ConditionExpression: aws.String("#status IN (:pending, :in_progress)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":status":      &dynamoTypes.AttributeValueMemberS{Value: status},
+			":pending":     &dynamoTypes.AttributeValueMemberS{Value: uploadstypes.StatusPending},
+			":in_progress": &dynamoTypes.AttributeValueMemberS{Value: uploadstypes.StatusInProgress},
+		},
+	})
+	if err != nil {
+		var ccf *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrUploadNotActive
+		}
+		return err
+	}
+	return nil
 }
@@ -3,6 +3,8 @@ package store
 import (
 	"context"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	apperror "github.com/Yulian302/lfusys-services-commons/errors"
@@ -14,13 +16,54 @@ import (
 	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+var ErrMFACodeUsed = errors.New("mfa code already used")
+
 type UserStore interface {
 	GetByEmail(ctx context.Context, email string) (*types.User, error)
 	Create(ctx context.Context, user types.User) error
+	Update(ctx context.Context, email string, update UserUpdate) error
+	ConsumeMFAStep(ctx context.Context, email string, step int64) error
+	ConsumeRecoveryCode(ctx context.Context, email string, codeHash string) error
+	List(ctx context.Context, filter UserFilter) ([]types.User, string, error)
+	Delete(ctx context.Context, email string) error
 
 	health.ReadinessCheck
 }
 
+// UserUpdate lists the attributes to change, nil fields are left untouched
+type UserUpdate struct {
+	Name *string
+	// an empty password removes it, leaving only passwordless logins
+	Password *string
+	Verified *bool
+
+	// an empty value removes the attribute
+	AvatarURL *string
+	Locale    *string
+	Timezone  *string
+
+	// an empty value removes the attribute
+	OAuthProvider *string
+	OAuthID       *string
+
+	// an empty secret or code list removes the attribute
+	MFAEnabled       *bool
+	MFASecret        *string
+	MFARecoveryCodes *[]string
+
+	// an empty list removes the attribute
+	Roles    *[]string
+	Disabled *bool
+}
+
+// UserFilter selects a page of users. Query matches a part of the email
+// address or name, case sensitive.
+type UserFilter struct {
+	Query  string
+	Limit  int32
+	Cursor string
+}
+
 type DynamoDbUserStore struct {
 	Client    *dynamodb.Client
 	TableName string
@@ -87,3 +130,247 @@ func (s *DynamoDbUserStore) Create(ctx context.Context, user types.User) error {
 	}
 	return nil
 }
+
+func (s *DynamoDbUserStore) Delete(ctx context.Context, email string) error {
+	_, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"email": &dynamoTypes.AttributeValueMemberS{Value: email},
+		},
+		ConditionExpression: aws.String("attribute_exists(email)"),
+	})
+	if err != nil {
+		var ccf *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return apperror.ErrUserNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Update applies the non-nil fields of update to an existing user.
+// Setting a password also drops the legacy salt.
+func (s *DynamoDbUserStore) Update(ctx context.Context, email string, update UserUpdate) error {
+	set := []string{}
+	remove := []string{}
+	values := map[string]dynamoTypes.AttributeValue{}
+	names := map[string]string{}
+
+	if update.Name != nil {
+		set = append(set, "#name = :name")
+		names["#name"] = "name"
+		values[":name"] = &dynamoTypes.AttributeValueMemberS{Value: *update.Name}
+	}
+	if update.AvatarURL != nil {
+		names["#avatar_url"] = "avatar_url"
+		if *update.AvatarURL == "" {
+			remove = append(remove, "#avatar_url")
+		} else {
+			set = append(set, "#avatar_url = :avatar_url")
+			values[":avatar_url"] = &dynamoTypes.AttributeValueMemberS{Value: *update.AvatarURL}
+		}
+	}
+	if update.Locale != nil {
+		names["#locale"] = "locale"
+		if *update.Locale == "" {
+			remove = append(remove, "#locale")
+		} else {
+			set = append(set, "#locale = :locale")
+			values[":locale"] = &dynamoTypes.AttributeValueMemberS{Value: *update.Locale}
+		}
+	}
+	if update.Timezone != nil {
+		names["#timezone"] = "timezone"
+		if *update.Timezone == "" {
+			remove = append(remove, "#timezone")
+		} else {
+			set = append(set, "#timezone = :timezone")
+			values[":timezone"] = &dynamoTypes.AttributeValueMemberS{Value: *update.Timezone}
+		}
+	}
+	if update.Password != nil {
+		remove = append(remove, "salt")
+		if *update.Password == "" {
+			remove = append(remove, "password")
+		} else {
+			set = append(set, "password = :password")
+			values[":password"] = &dynamoTypes.AttributeValueMemberS{Value: *update.Password}
+		}
+	}
+	if update.Verified != nil {
+		set = append(set, "Verified = :verified")
+		values[":verified"] = &dynamoTypes.AttributeValueMemberBOOL{Value: *update.Verified}
+	}
+	if update.OAuthProvider != nil {
+		if *update.OAuthProvider == "" {
+			remove = append(remove, "OAuthProvider")
+		} else {
+			set = append(set, "OAuthProvider = :oauth_provider")
+			values[":oauth_provider"] = &dynamoTypes.AttributeValueMemberS{Value: *update.OAuthProvider}
+		}
+	}
+	if update.OAuthID != nil {
+		if *update.OAuthID == "" {
+			remove = append(remove, "OAuthID")
+		} else {
+			set = append(set, "OAuthID = :oauth_id")
+			values[":oauth_id"] = &dynamoTypes.AttributeValueMemberS{Value: *update.OAuthID}
+		}
+	}
+	if update.MFAEnabled != nil {
+		set = append(set, "mfa_enabled = :mfa_enabled")
+		values[":mfa_enabled"] = &dynamoTypes.AttributeValueMemberBOOL{Value: *update.MFAEnabled}
+	}
+	if update.MFASecret != nil {
+		if *update.MFASecret == "" {
+			remove = append(remove, "mfa_secret")
+		} else {
+			set = append(set, "mfa_secret = :mfa_secret")
+			values[":mfa_secret"] = &dynamoTypes.AttributeValueMemberS{Value: *update.MFASecret}
+		}
+	}
+	if update.MFARecoveryCodes != nil {
+		if len(*update.MFARecoveryCodes) == 0 {
+			remove = append(remove, "mfa_recovery_codes")
+		} else {
+			set = append(set, "mfa_recovery_codes = :mfa_recovery_codes")
+			values[":mfa_recovery_codes"] = &dynamoTypes.AttributeValueMemberSS{Value: *update.MFARecoveryCodes}
+		}
+	}
+	if update.Roles != nil {
+		if len(*update.Roles) == 0 {
+			remove = append(remove, "#roles")
+			names["#roles"] = "roles"
+		} else {
+			set = append(set, "#roles = :roles")
+			names["#roles"] = "roles"
+			values[":roles"] = &dynamoTypes.AttributeValueMemberSS{Value: *update.Roles}
+		}
+	}
+	if update.Disabled != nil {
+		set = append(set, "disabled = :disabled")
+		values[":disabled"] = &dynamoTypes.AttributeValueMemberBOOL{Value: *update.Disabled}
+	}
+
+	expr := ""
+	if len(set) > 0 {
+		expr = "SET " + strings.Join(set, ", ")
+	}
+	if len(remove) > 0 {
+		expr = strings.TrimSpace(expr + " REMOVE " + strings.Join(remove, ", "))
+	}
+	if expr == "" {
+		return nil
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"email": &dynamoTypes.AttributeValueMemberS{Value: email},
+		},
+		UpdateExpression:    aws.String(expr),
+		ConditionExpression: aws.String("attribute_exists(email)"),
+	}
+	if len(values) > 0 {
+		input.ExpressionAttributeValues = values
+	}
+	if len(names) > 0 {
+		input.ExpressionAttributeNames = names
+	}
+
+	_, err := s.Client.UpdateItem(ctx, input)
+	if err != nil {
+		var ccf *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return apperror.ErrUserNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// ConsumeMFAStep records the time step of an accepted TOTP code. A code of
+// the same or an earlier step was already used and is rejected.
+func (s *DynamoDbUserStore) ConsumeMFAStep(ctx context.Context, email string, step int64) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"email": &dynamoTypes.AttributeValueMemberS{Value: email},
+		},
+		UpdateExpression:    aws.String("SET mfa_last_step = :step"),
+		ConditionExpression: aws.String("attribute_exists(email) AND (attribute_not_exists(mfa_last_step) OR mfa_last_step < :step)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":step": &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(step, 10)},
+		},
+	})
+	if err != nil {
+		var ccf *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrMFACodeUsed
+		}
+		return err
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode removes a hashed recovery code, failing if it is not (or no longer) there
+func (s *DynamoDbUserStore) ConsumeRecoveryCode(ctx context.Context, email string, codeHash string) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]dynamoTypes.AttributeValue{
+			"email": &dynamoTypes.AttributeValueMemberS{Value: email},
+		},
+		UpdateExpression:    aws.String("DELETE mfa_recovery_codes :codes"),
+		ConditionExpression: aws.String("contains(mfa_recovery_codes, :code)"),
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":codes": &dynamoTypes.AttributeValueMemberSS{Value: []string{codeHash}},
+			":code":  &dynamoTypes.AttributeValueMemberS{Value: codeHash},
+		},
+	})
+	if err != nil {
+		var ccf *dynamoTypes.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrMFACodeUsed
+		}
+		return err
+	}
+	return nil
+}
+
+// List scans the users table and returns the next cursor, empty once
+// the whole table was read. A page may hold fewer than Limit users.
+func (s *DynamoDbUserStore) List(ctx context.Context, filter UserFilter) ([]types.User, string, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(s.TableName),
+		Limit:     aws.Int32(filter.Limit),
+	}
+	if filter.Query != "" {
+		input.FilterExpression = aws.String("contains(email, :q) OR contains(#name, :q)")
+		input.ExpressionAttributeNames = map[string]string{"#name": "name"}
+		input.ExpressionAttributeValues = map[string]dynamoTypes.AttributeValue{
+			":q": &dynamoTypes.AttributeValueMemberS{Value: filter.Query},
+		}
+	}
+	if filter.Cursor != "" {
+		input.ExclusiveStartKey = map[string]dynamoTypes.AttributeValue{
+			"email": &dynamoTypes.AttributeValueMemberS{Value: filter.Cursor},
+		}
+	}
+
+	out, err := s.Client.Scan(ctx, input)
+	if err != nil {
+		return nil, "", err
+	}
+
+	users := []types.User{}
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &users); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if key, ok := out.LastEvaluatedKey["email"].(*dynamoTypes.AttributeValueMemberS); ok {
+		next = key.Value
+	}
+	return users, next, nil
+}
@@ -2,6 +2,7 @@ package uploads
 
 import (
 	error "errors"
+	"fmt"
 	"net/http"
 
 	"github.com/Yulian302/lfusys-services-commons/errors"
@@ -34,6 +35,9 @@ type UploadRequest struct {
 // @Success      200  {object}  UploadResponse "Upload info"
 // @Failure      401  {object}  HTTPError "Not authenticated"
 // @Failure      400  {object}  HTTPError "Bad request params"
+// @Failure      409  {object}  uploadstypes.UploadConflict "Too many uploads in progress"
+// @Failure      413  {object}  uploadstypes.QuotaExceeded "File is larger than the storage quota"
+// @Failure      507  {object}  uploadstypes.QuotaExceeded "Not enough storage left"
 // @Failure      500  {object}  HTTPError
 // @Router       /uploads/start [post]
 func (h *UploadsHandler) StartUpload(ctx *gin.Context) {
@@ -49,10 +53,30 @@ func (h *UploadsHandler) StartUpload(ctx *gin.Context) {
 		return
 	}
 
-	uploadResp, err := h.uploadsService.StartUpload(ctx, email, int64(uploadReq.FileSize))
+	uploadResp, err := h.uploadsService.StartUpload(ctx, email, ctx.GetStringSlice("roles"), int64(uploadReq.FileSize))
 	if err != nil {
-		if error.Is(err, errors.ErrFileSizeExceeded) || error.Is(err, errors.ErrFileSizeInvalid) {
+		var limitErr *services.UploadLimitError
+		var quotaErr *services.QuotaExceededError
+		if error.As(err, &quotaErr) {
+			code, msg := http.StatusInsufficientStorage, "not enough storage left"
+			if quotaErr.TooLarge() {
+				code, msg = http.StatusRequestEntityTooLarge, "file is larger than your storage quota"
+			}
+			ctx.JSON(code, uploadstypes.QuotaExceeded{
+				Error:          fmt.Sprintf("%s, %d bytes remaining", msg, quotaErr.Remaining()),
+				LimitBytes:     quotaErr.Limit,
+				RemainingBytes: quotaErr.Remaining(),
+				RequestedBytes: quotaErr.Requested,
+			})
+		} else if error.Is(err, errors.ErrFileSizeExceeded) || error.Is(err, errors.ErrFileSizeInvalid) {
 			errors.BadRequestResponse(ctx, "file cannot be larger than 10GB")
+		} else if error.As(err, &limitErr) {
+			// the frontend offers to cancel these
+			ctx.JSON(http.StatusConflict, uploadstypes.UploadConflict{
+				Error:     "too many uploads in progress",
+				Limit:     limitErr.Limit,
+				UploadIds: limitErr.UploadIDs,
+			})
 		} else if error.Is(err, errors.ErrSessionConflict) {
 			errors.ConflictResponse(ctx, "upload session already exists")
 		} else if error.Is(err, errors.ErrServiceUnavailable) {
@@ -71,20 +95,27 @@ func (h *UploadsHandler) StartUpload(ctx *gin.Context) {
 }
 
 func (h *UploadsHandler) GetUploadStatus(c *gin.Context) {
+	email := c.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
 	uploadId := c.Param("uploadId")
 	if uploadId == "" {
 		errors.BadRequestResponse(c, "upload id is required")
 		return
 	}
 
-	resp, err := h.uploadsService.GetUploadStatus(c, uploadId)
+	resp, err := h.uploadsService.GetUploadStatus(c, email, uploadId)
 	if err != nil {
 		if error.Is(err, errors.ErrGrpcFailed) {
 			errors.InternalServerErrorResponse(c, "grpc failed")
 		} else if error.Is(err, errors.ErrServiceUnavailable) {
 			errors.ServiceUnavailableResponse(c, "upload service unavailable")
 		} else if error.Is(err, errors.ErrSessionNotFound) {
-			errors.ForbiddenResponse(c, "upload session not found")
+			// uploads of other users look just like unknown ones
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
 		} else {
 			errors.InternalServerErrorResponse(c, err.Error())
 		}
@@ -93,3 +124,71 @@ func (h *UploadsHandler) GetUploadStatus(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// ListUploads returns the caller's uploads that are pending or in progress
+func (h *UploadsHandler) ListUploads(c *gin.Context) {
+	email := c.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	list, err := h.uploadsService.ListUploads(c, email)
+	if err != nil {
+		errors.InternalServerErrorResponse(c, "could not list uploads")
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (h *UploadsHandler) CancelUpload(c *gin.Context) {
+	email := c.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	if err := h.uploadsService.CancelUpload(c, email, c.Param("uploadId")); err != nil {
+		if error.Is(err, errors.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+		} else if error.Is(err, services.ErrUploadFinished) {
+			errors.ConflictResponse(c, err.Error())
+		} else {
+			errors.InternalServerErrorResponse(c, "could not cancel upload")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "upload cancelled"})
+}
+
+// Usage godoc
+// @Summary      Storage usage
+// @Description  Bytes stored and reserved by uploads in progress, and the caller's limits
+// @Tags         uploads
+// @Produce      json
+// @Success      200  {object}  uploadstypes.StorageUsage
+// @Failure      401  {object}  HTTPError "Not authenticated"
+// @Failure      403  {object}  HTTPError "Not allowed with an API key"
+// @Failure      503  {object}  HTTPError
+// @Router       /auth/me/usage [get]
+func (h *UploadsHandler) Usage(c *gin.Context) {
+	email := c.GetString("email")
+	if email == "" {
+		errors.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	usage, err := h.uploadsService.Usage(c, email, c.GetStringSlice("roles"))
+	if err != nil {
+		if error.Is(err, errors.ErrServiceUnavailable) {
+			errors.ServiceUnavailableResponse(c, "upload service unavailable")
+		} else {
+			errors.InternalServerErrorResponse(c, "could not get storage usage")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
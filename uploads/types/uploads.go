@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type UploadResponse struct {
 	TotalChunks uint32   `json:"total_chunks"`
 	UploadUrls  []string `json:"upload_urls"`
@@ -11,3 +13,55 @@ type UploadStatusResponse struct {
 	Progress uint32 `json:"progress"`
 	Message  string `json:"message"`
 }
+
+const (
+	StatusPending    = "pending"
+	StatusInProgress = "in_progress"
+	StatusCancelled  = "cancelled"
+	StatusExpired    = "expired"
+)
+
+// Upload is an upload session as the session service keeps it in the uploads table
+type Upload struct {
+	UploadId    string    `json:"upload_id" dynamodbav:"upload_id"`
+	UserEmail   string    `json:"-" dynamodbav:"user_email"`
+	Status      string    `json:"status" dynamodbav:"status"`
+	FileSize    uint64    `json:"file_size" dynamodbav:"file_size"`
+	TotalChunks uint32    `json:"total_chunks" dynamodbav:"total_chunks"`
+	CreatedAt   time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// Active tells whether the upload may still receive chunks
+func (u *Upload) Active() bool {
+	return u.Status == StatusPending || u.Status == StatusInProgress
+}
+
+type UploadList struct {
+	Uploads []Upload `json:"uploads"`
+}
+
+// UploadConflict names the uploads that keep a user from starting another one
+type UploadConflict struct {
+	Error     string   `json:"error"`
+	Limit     int64    `json:"limit"`
+	UploadIds []string `json:"upload_ids"`
+}
+
+// StorageUsage is how much of their quota a user has taken. Limits of 0 are unlimited.
+type StorageUsage struct {
+	UsedBytes      int64  `json:"used_bytes"`
+	ReservedBytes  int64  `json:"reserved_bytes"`
+	LimitBytes     int64  `json:"limit_bytes"`
+	RemainingBytes *int64 `json:"remaining_bytes,omitempty"`
+	Files          int    `json:"files"`
+	ActiveUploads  int    `json:"active_uploads"`
+	UploadLimit    int64  `json:"upload_limit"`
+}
+
+// QuotaExceeded tells how much room is left when an upload does not fit
+type QuotaExceeded struct {
+	Error          string `json:"error"`
+	LimitBytes     int64  `json:"limit_bytes"`
+	RemainingBytes int64  `json:"remaining_bytes"`
+	RequestedBytes int64  `json:"requested_bytes"`
+}
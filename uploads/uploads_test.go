@@ -2,27 +2,95 @@ package uploads_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	cerr "errors"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 
+	pb "github.com/Yulian302/lfusys-services-commons/api"
 	"github.com/Yulian302/lfusys-services-commons/config"
+	"github.com/Yulian302/lfusys-services-commons/errors"
 	"github.com/Yulian302/lfusys-services-commons/test"
 	"github.com/Yulian302/lfusys-services-commons/test/mocks"
+	"github.com/Yulian302/lfusys-services-gateway/auth"
+	"github.com/Yulian302/lfusys-services-gateway/auth/handlers"
+	"github.com/Yulian302/lfusys-services-gateway/auth/keys"
+	filetypes "github.com/Yulian302/lfusys-services-gateway/files/types"
 	"github.com/Yulian302/lfusys-services-gateway/routers"
 	"github.com/Yulian302/lfusys-services-gateway/services"
+	"github.com/Yulian302/lfusys-services-gateway/store"
 	"github.com/Yulian302/lfusys-services-gateway/uploads"
+	uploadstypes "github.com/Yulian302/lfusys-services-gateway/uploads/types"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
 )
 
 var (
-	cfg       config.Config
-	mockStore *mocks.MockDynamoDbStore
-	r         *gin.Engine
+	cfg            config.Config
+	mockStore      *uploadsStoreMock
+	uploadsService *services.UploadsServiceImpl
+	r              *gin.Engine
 )
 
+// uploadsStoreMock adds the gateway specific UploadsStore methods to the shared mock
+type uploadsStoreMock struct {
+	*mocks.MockDynamoDbStore
+}
+
+func (m *uploadsStoreMock) Get(ctx context.Context, uploadID string) (*uploadstypes.Upload, error) {
+	args := m.Called(ctx, uploadID)
+	upload, _ := args.Get(0).(*uploadstypes.Upload)
+	return upload, args.Error(1)
+}
+
+func (m *uploadsStoreMock) ListActive(ctx context.Context, email string) ([]uploadstypes.Upload, error) {
+	args := m.Called(ctx, email)
+	uploads, _ := args.Get(0).([]uploadstypes.Upload)
+	return uploads, args.Error(1)
+}
+
+func (m *uploadsStoreMock) Finish(ctx context.Context, uploadID string, status string) error {
+	args := m.Called(ctx, uploadID, status)
+	return args.Error(0)
+}
+
+// fakeUploader answers for the session service
+type fakeUploader struct {
+	pb.UploaderClient
+}
+
+func (*fakeUploader) StartUpload(ctx context.Context, in *pb.UploadRequest, opts ...grpc.CallOption) (*pb.UploadReply, error) {
+	return &pb.UploadReply{UploadId: "new", TotalChunks: 1, UploadUrls: []string{"https://storage/0"}}, nil
+}
+
+func (*fakeUploader) GetUploadStatus(ctx context.Context, in *pb.UploadID, opts ...grpc.CallOption) (*pb.UploadStatusReply, error) {
+	return &pb.UploadStatusReply{Status: "in_progress", Progress: 50}, nil
+}
+
+// storedFiles are the finished files of every user
+type storedFiles []*filetypes.File
+
+func (f storedFiles) GetFiles(ctx context.Context, email string) (*filetypes.FilesResponse, error) {
+	return &filetypes.FilesResponse{Files: f}, nil
+}
+
+// withQuota gives every user limit bytes with used of them taken
+func withQuota(t *testing.T, limit int64, used uint64) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	files := storedFiles{{FileId: "f1", Size: used}}
+
+	uploadsService.Quota = services.NewQuotaService(store.NewRedisQuotaStore(rdb), mockStore, files, services.RoleLimits{Default: limit})
+	t.Cleanup(func() { uploadsService.Quota = nil })
+}
+
 func TestMain(m *testing.M) {
 	os.Setenv("JWT_SECRET_KEY", "test-secret")
 	defer os.Unsetenv("JWT_SECRET_KEY")
@@ -32,29 +100,33 @@ func TestMain(m *testing.M) {
 	r = gin.Default()
 
 	cfg = config.LoadConfig()
-	mockStore = &mocks.MockDynamoDbStore{}
+	mockStore = &uploadsStoreMock{&mocks.MockDynamoDbStore{}}
 
-	uploadsService := services.NewUploadsService(mockStore, nil, nil)
+	uploadsService = services.NewUploadsService(mockStore, &fakeUploader{}, gobreaker.NewCircuitBreaker[*pb.UploadReply](gobreaker.Settings{}))
 	uploadsHandler := uploads.NewUploadsHandler(uploadsService)
 
-	routers.RegisterUploadsRoutes(uploadsHandler, cfg.JWTConfig.SecretKey, r)
+	requireAuth := auth.JWTMiddleware(keys.HS256(cfg.JWTConfig.SecretKey), nil, nil)
+	routers.RegisterUploadsRoutes(uploadsHandler, requireAuth, auth.RequireVerified(false), r)
+	routers.RegisterAccountRoutes(handlers.NewAccountHandler(nil), uploadsHandler, requireAuth, r)
 
 	os.Exit(m.Run())
 }
 
+// withUploadLimit lets every user have limit uploads at once
+func withUploadLimit(t *testing.T, limit services.RoleLimits) {
+	limits := uploadsService.ConcurrencyLimit
+	uploadsService.ConcurrencyLimit = limit
+	t.Cleanup(func() { uploadsService.ConcurrencyLimit = limits })
+}
+
 func TestCreateUploadSession_AlreadyExists(t *testing.T) {
 	mockStore.ResetMock()
+	withUploadLimit(t, services.RoleLimits{Default: 1})
 
-	mockStore.On(
-		"FindExisting",
-		mock.Anything,
-		mock.MatchedBy(func(email string) bool {
-			return email != ""
-		}),
-	).Return(
-		true,
+	mockStore.On("ListActive", mock.Anything, "test@gmail.com").Return(
+		[]uploadstypes.Upload{{UploadId: "up1", UserEmail: "test@gmail.com", Status: uploadstypes.StatusInProgress}},
 		nil,
-	)
+	).Once()
 
 	reqBody := uploads.UploadRequest{
 		FileSize: 100,
@@ -74,4 +146,279 @@ func TestCreateUploadSession_AlreadyExists(t *testing.T) {
 	)
 
 	assert.Equal(t, 409, w.Code)
+
+	var conflict uploadstypes.UploadConflict
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &conflict))
+	assert.Equal(t, []string{"up1"}, conflict.UploadIds)
+	mockStore.AssertExpectations(t)
+}
+
+func TestCreateUploadSession_NoActiveUploads(t *testing.T) {
+	mockStore.ResetMock()
+	withUploadLimit(t, services.RoleLimits{Default: 1})
+
+	mockStore.On("ListActive", mock.Anything, "test@gmail.com").Return(nil, nil)
+
+	body, _ := json.Marshal(uploads.UploadRequest{FileSize: 100})
+	w := test.PerformRequest(r, t, "POST", "/uploads/start", bytes.NewReader(body), []string{"Content-Type: application/json"}, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCreateUploadSession_NoLimitByDefault(t *testing.T) {
+	mockStore.ResetMock()
+
+	body, _ := json.Marshal(uploads.UploadRequest{FileSize: 100})
+	w := test.PerformRequest(r, t, "POST", "/uploads/start", bytes.NewReader(body), []string{"Content-Type: application/json"}, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockStore.AssertNotCalled(t, "ListActive", mock.Anything, mock.Anything)
+}
+
+func TestCreateUploadSession_RoleLimit(t *testing.T) {
+	mockStore.ResetMock()
+	withUploadLimit(t, services.RoleLimits{Default: 1, ByRole: map[string]int64{"pro": 2}})
+
+	mockStore.On("ListActive", mock.Anything, "test@gmail.com").Return(
+		[]uploadstypes.Upload{{UploadId: "up1", UserEmail: "test@gmail.com", Status: uploadstypes.StatusInProgress}},
+		nil,
+	)
+
+	_, err := uploadsService.StartUpload(context.Background(), "test@gmail.com", []string{"user"}, 100)
+	assert.ErrorIs(t, err, errors.ErrSessionConflict)
+
+	_, err = uploadsService.StartUpload(context.Background(), "test@gmail.com", []string{"user", "pro"}, 100)
+	assert.NoError(t, err)
+}
+
+func TestGetUploadStatus_Owner(t *testing.T) {
+	mockStore.ResetMock()
+
+	mockStore.On("Get", mock.Anything, "up1").Return(&uploadstypes.Upload{UploadId: "up1", UserEmail: "test@gmail.com"}, nil)
+
+	w := test.PerformRequest(r, t, "GET", "/uploads/up1/status", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "in_progress")
+}
+
+func TestGetUploadStatus_OthersUploadIsNotFound(t *testing.T) {
+	mockStore.ResetMock()
+
+	mockStore.On("Get", mock.Anything, "up1").Return(&uploadstypes.Upload{UploadId: "up1", UserEmail: "owner@gmail.com"}, nil)
+	mockStore.On("Get", mock.Anything, "missing").Return(nil, store.ErrUploadNotFound)
+
+	w := test.PerformRequest(r, t, "GET", "/uploads/up1/status", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// the same answer as for uploads that do not exist at all
+	missing := test.PerformRequest(r, t, "GET", "/uploads/missing/status", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, w.Code, missing.Code)
+	assert.Equal(t, w.Body.String(), missing.Body.String())
+}
+
+func TestListUploads(t *testing.T) {
+	mockStore.ResetMock()
+
+	mockStore.On("ListActive", mock.Anything, "test@gmail.com").Return(
+		[]uploadstypes.Upload{{UploadId: "up1", UserEmail: "test@gmail.com", Status: uploadstypes.StatusInProgress}},
+		nil,
+	)
+
+	w := test.PerformRequest(r, t, "GET", "/uploads", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list uploadstypes.UploadList
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Uploads, 1)
+	assert.Equal(t, "up1", list.Uploads[0].UploadId)
+}
+
+func TestCancelUpload(t *testing.T) {
+	mockStore.ResetMock()
+
+	mockStore.On("Get", mock.Anything, "up1").Return(&uploadstypes.Upload{UploadId: "up1", UserEmail: "test@gmail.com", Status: uploadstypes.StatusInProgress}, nil)
+	mockStore.On("Get", mock.Anything, "done").Return(&uploadstypes.Upload{UploadId: "done", UserEmail: "test@gmail.com", Status: "completed"}, nil)
+	mockStore.On("Finish", mock.Anything, "up1", uploadstypes.StatusCancelled).Return(nil)
+
+	w := test.PerformRequest(r, t, "DELETE", "/uploads/up1", nil, nil, true, cfg.JWTConfig.SecretKey, "other@gmail.com")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockStore.AssertNotCalled(t, "Finish", mock.Anything, "up1", mock.Anything)
+
+	w = test.PerformRequest(r, t, "DELETE", "/uploads/up1", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = test.PerformRequest(r, t, "DELETE", "/uploads/done", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestUploadReaper_ExpiresStaleUploads(t *testing.T) {
+	mockStore.ResetMock()
+	ctx := context.Background()
+
+	activity := store.NewRedisUploadActivityStore(redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}))
+	uploadsService.Activity = activity
+	t.Cleanup(func() { uploadsService.Activity = nil })
+
+	ttl := time.Hour
+	for _, id := range []string{"stale1", "stale2", "stale3", "gone"} {
+		assert.NoError(t, activity.Touch(ctx, id, time.Now().Add(-2*ttl)))
+	}
+	assert.NoError(t, activity.Touch(ctx, "fresh", time.Now()))
+
+	mockStore.On("Get", mock.Anything, "stale1").Return(&uploadstypes.Upload{UploadId: "stale1", UserEmail: "test@gmail.com", Status: uploadstypes.StatusInProgress}, nil)
+	// completed by the session service in the meantime
+	mockStore.On("Get", mock.Anything, "stale2").Return(&uploadstypes.Upload{UploadId: "stale2", UserEmail: "test@gmail.com", Status: "completed"}, nil)
+	mockStore.On("Get", mock.Anything, "stale3").Return(&uploadstypes.Upload{UploadId: "stale3", UserEmail: "test@gmail.com", Status: uploadstypes.StatusPending}, nil)
+	mockStore.On("Get", mock.Anything, "gone").Return(nil, store.ErrUploadNotFound)
+	mockStore.On("Finish", mock.Anything, "stale1", uploadstypes.StatusExpired).Return(nil)
+	// completed between the lookup and the update
+	mockStore.On("Finish", mock.Anything, "stale3", uploadstypes.StatusExpired).Return(store.ErrUploadNotActive)
+
+	expired, err := uploadsService.ExpireStale(ctx, ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	mockStore.AssertExpectations(t)
+
+	// every stale upload was dealt with, only the fresh one is still tracked
+	stale, err := activity.Stale(ctx, time.Now().Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fresh"}, stale)
+
+	assert.NoError(t, activity.Touch(ctx, "stale1", time.Now().Add(-2*ttl)))
+	reaper := services.NewUploadReaper(uploadsService, ttl, 10*time.Millisecond)
+	reaper.Start()
+	assert.Eventually(t, func() bool {
+		stale, err := activity.Stale(ctx, time.Now().Add(-ttl), 10)
+		return err == nil && len(stale) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, reaper.Stop(context.Background()))
+}
+
+func TestStartUpload_StorageQuota(t *testing.T) {
+	mockStore.ResetMock()
+	withQuota(t, 1000, 600)
+
+	mockStore.On("ListActive", mock.Anything, "test@gmail.com").Return(
+		[]uploadstypes.Upload{{UploadId: "new", UserEmail: "test@gmail.com", Status: uploadstypes.StatusPending}},
+		nil,
+	).Once()
+
+	start := func(size uint64) (int, uploadstypes.QuotaExceeded) {
+		body, _ := json.Marshal(uploads.UploadRequest{FileSize: size})
+		w := test.PerformRequest(r, t, "POST", "/uploads/start", bytes.NewReader(body), []string{"Content-Type: application/json"}, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+		var resp uploadstypes.QuotaExceeded
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, resp := start(2000)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, int64(400), resp.RemainingBytes)
+
+	code, resp = start(500)
+	assert.Equal(t, http.StatusInsufficientStorage, code)
+	assert.Equal(t, int64(400), resp.RemainingBytes)
+	assert.Contains(t, resp.Error, "400 bytes remaining")
+
+	code, _ = start(300)
+	assert.Equal(t, http.StatusOK, code)
+
+	// the reservation of the new upload counts until it is cancelled
+	code, resp = start(300)
+	assert.Equal(t, http.StatusInsufficientStorage, code)
+	assert.Equal(t, int64(100), resp.RemainingBytes)
+}
+
+// unavailableFiles fails like the session service while it is down
+type unavailableFiles struct{}
+
+func (unavailableFiles) GetFiles(ctx context.Context, email string) (*filetypes.FilesResponse, error) {
+	return nil, cerr.New("session service unavailable")
+}
+
+func TestStartUpload_NoQuotaDoesNotCountFiles(t *testing.T) {
+	mockStore.ResetMock()
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	uploadsService.Quota = services.NewQuotaService(store.NewRedisQuotaStore(rdb), mockStore, unavailableFiles{}, services.RoleLimits{
+		Default: 1000,
+		ByRole:  map[string]int64{"admin": 0},
+	})
+	t.Cleanup(func() { uploadsService.Quota = nil })
+
+	_, err := uploadsService.StartUpload(context.Background(), "admin@gmail.com", []string{"admin"}, 300)
+	assert.NoError(t, err)
+
+	_, err = uploadsService.StartUpload(context.Background(), "test@gmail.com", nil, 300)
+	assert.ErrorIs(t, err, errors.ErrServiceUnavailable)
+}
+
+// unconfirmedQuota reserves but cannot confirm, e.g. while redis is down
+type unconfirmedQuota struct {
+	services.UploadQuota
+	released []string
+}
+
+func (q *unconfirmedQuota) Reserve(ctx context.Context, email string, roles []string, bytes int64) (string, error) {
+	return "pending:1", nil
+}
+
+func (q *unconfirmedQuota) Confirm(ctx context.Context, email string, reservationID string, uploadID string) error {
+	return cerr.New("redis unavailable")
+}
+
+func (q *unconfirmedQuota) Release(ctx context.Context, email string, id string) error {
+	q.released = append(q.released, id)
+	return nil
+}
+
+func TestStartUpload_UnconfirmedReservationCancelsUpload(t *testing.T) {
+	mockStore.ResetMock()
+	quota := &unconfirmedQuota{}
+	uploadsService.Quota = quota
+	t.Cleanup(func() { uploadsService.Quota = nil })
+
+	mockStore.On("Finish", mock.Anything, "new", uploadstypes.StatusCancelled).Return(nil).Once()
+
+	_, err := uploadsService.StartUpload(context.Background(), "test@gmail.com", nil, 300)
+	assert.Error(t, err)
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, []string{"pending:1"}, quota.released)
+}
+
+func TestUsage(t *testing.T) {
+	mockStore.ResetMock()
+	withQuota(t, 1000, 600)
+
+	_, err := uploadsService.StartUpload(context.Background(), "test@gmail.com", nil, 300)
+	assert.NoError(t, err)
+
+	active := []uploadstypes.Upload{{UploadId: "new", UserEmail: "test@gmail.com", Status: uploadstypes.StatusPending}}
+	mockStore.On("ListActive", mock.Anything, "test@gmail.com").Return(active, nil).Times(2)
+
+	usage := func() uploadstypes.StorageUsage {
+		w := test.PerformRequest(r, t, "GET", "/auth/me/usage", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var usage uploadstypes.StorageUsage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &usage))
+		return usage
+	}
+
+	got := usage()
+	assert.Equal(t, int64(600), got.UsedBytes)
+	assert.Equal(t, int64(300), got.ReservedBytes)
+	assert.Equal(t, int64(1000), got.LimitBytes)
+	if assert.NotNil(t, got.RemainingBytes) {
+		assert.Equal(t, int64(100), *got.RemainingBytes)
+	}
+	assert.Equal(t, 1, got.ActiveUploads)
+
+	mockStore.On("Get", mock.Anything, "new").Return(&active[0], nil)
+	mockStore.On("Finish", mock.Anything, "new", uploadstypes.StatusCancelled).Return(nil)
+	w := test.PerformRequest(r, t, "DELETE", "/uploads/new", nil, nil, true, cfg.JWTConfig.SecretKey, "test@gmail.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockStore.On("ListActive", mock.Anything, "test@gmail.com").Return(nil, nil)
+	got = usage()
+	assert.Equal(t, int64(0), got.ReservedBytes)
+	assert.Equal(t, int64(400), *got.RemainingBytes)
+	assert.Equal(t, 0, got.ActiveUploads)
 }